@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const maxDisplayNameLength = 64
+const maxAvatarLength = 512
+
+// public information about the author of a comment
+type Author struct {
+	Uid    int     `json:"uid"`
+	Name   string  `json:"name"`
+	Avatar *string `json:"avatar,omitempty"`
+}
+
+// returns the public name of a user: the display-name if set, otherwise the login-name
+func (user User) publicName() string {
+	if user.Display != nil && *user.Display != "" {
+		return *user.Display
+	} else {
+		return user.Name
+	}
+}
+
+// retrieves the public information of the given users
+func getAuthors(uids []int) (map[int]Author, error) {
+	authors := map[int]Author{}
+
+	if len(uids) == 0 {
+		return authors, nil
+	}
+
+	args := make([]any, len(uids))
+
+	for ii, uid := range uids {
+		args[ii] = uid
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(uids)), ", ")
+
+	if users, err := dbSelect[User]("users", fmt.Sprintf("uid IN (%s) AND deleted IS NULL", placeholders), args...); err != nil {
+		return nil, err
+	} else {
+		for _, user := range users {
+			authors[user.Uid] = Author{
+				Uid:    user.Uid,
+				Name:   user.publicName(),
+				Avatar: user.Avatar,
+			}
+		}
+	}
+
+	return authors, nil
+}
+
+type AccountInfo struct {
+	Uid         int          `json:"uid"`
+	Name        string       `json:"name"`
+	DisplayName *string      `json:"display_name"`
+	Avatar      *string      `json:"avatar"`
+	Admin       bool         `json:"admin"`
+	Role        Role         `json:"role"`
+	Permissions []Permission `json:"permissions"`
+	TwoFactor   bool         `json:"two_factor"`
+}
+
+func getAccount(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if users, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL LIMIT 1", uid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(users) != 1 {
+		response.Status = fiber.StatusBadRequest
+	} else {
+		user := users[0]
+
+		response.Data = AccountInfo{
+			Uid:         user.Uid,
+			Name:        user.Name,
+			DisplayName: user.Display,
+			Avatar:      user.Avatar,
+			Admin:       user.Role.admin(),
+			Role:        user.Role,
+			Permissions: user.Role.permissions(),
+			TwoFactor:   user.TotpEnabled,
+		}
+	}
+
+	return response
+}
+
+// converts an empty string into nil to clear the column
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	} else {
+		return &s
+	}
+}
+
+func patchAccount(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		DisplayName string `json:"display_name"`
+		Avatar      string `json:"avatar"`
+	})
+
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ display_name string; avatar string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else {
+		body.DisplayName = strings.TrimSpace(body.DisplayName)
+		body.Avatar = strings.TrimSpace(body.Avatar)
+
+		if utf8.RuneCountInString(body.DisplayName) > maxDisplayNameLength {
+			logger.Info("display-name is too long")
+			response.Status = fiber.StatusBadRequest
+
+			// avatars are either uploaded files or external images
+		} else if body.Avatar != "" && (len(body.Avatar) > maxAvatarLength ||
+			!(strings.HasPrefix(body.Avatar, "/") || strings.HasPrefix(body.Avatar, "https://") || strings.HasPrefix(body.Avatar, "http://"))) {
+			logger.Sugar().Infof("invalid avatar %q", body.Avatar)
+			response.Status = fiber.StatusBadRequest
+		} else if _, err := db.Exec("UPDATE users SET display = ?, avatar = ? WHERE uid = ?", nilIfEmpty(body.DisplayName), nilIfEmpty(body.Avatar), uid); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			response = getAccount(c)
+		}
+	}
+
+	return response
+}
+
+// checks a password against the configured password-policy
+func validPassword(password string) error {
+	letter := false
+	digit := false
+
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+
+	if utf8.RuneCountInString(password) < Config.Passwords.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", Config.Passwords.MinLength)
+
+		// bcrypt only uses the first 72 bytes
+	} else if len(password) > 72 {
+		return fmt.Errorf("password must not be longer than 72 bytes")
+	} else if Config.Passwords.RequireLetter && !letter {
+		return fmt.Errorf("password must contain a letter")
+	} else if Config.Passwords.RequireDigit && !digit {
+		return fmt.Errorf("password must contain a digit")
+	} else {
+		return nil
+	}
+}
+
+func postAccountPassword(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Current string `json:"current"`
+		New     string `json:"new"`
+	})
+
+	if claims, err := extractClaims(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ current string; new string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if users, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL LIMIT 1", claims.Uid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(users) != 1 {
+		response.Status = fiber.StatusBadRequest
+
+		// a stolen session mustn't allow guessing the password faster than the login
+	} else if !checkLoginAllowed(c, users[0].Name) {
+		response.Status = fiber.StatusTooManyRequests
+		response.Message = "Too many failed attempts"
+	} else if bcrypt.CompareHashAndPassword(users[0].Password.Reveal(), []byte(body.Current)) != nil {
+		logger.Sugar().Infof("password-change of user %d failed: wrong password", claims.Uid)
+		response.Status = fiber.StatusForbidden
+		response.Message = "wrong password"
+
+		limiter.fail(c.IP(), users[0].Name, time.Now())
+	} else if err := validPassword(body.New); err != nil {
+		response.Status = fiber.StatusBadRequest
+		response.Message = err.Error()
+	} else if hashedPassword, err := hashPassword(body.New); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+
+	} else if _, err := db.Exec("UPDATE users SET password = ? WHERE uid = ?", newSecret(hashedPassword), claims.Uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+
+		// log out the user everywhere
+	} else if err := incTokenId(claims.Uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+
+		// and start a new session on this device
+	} else if sid, err := createSession(c, claims.Uid); err != nil {
+		logger.Sugar().Errorf("can't create session: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else if tid, err := getTokenId(claims.Uid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if err := setSessionCookie(c, claims.Uid, tid, sid); err != nil {
+		logger.Sugar().Errorf("failed creating json-webtoken: %v", err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		logger.Sugar().Infof("user %d changed their password", claims.Uid)
+
+		response.Status = fiber.StatusOK
+	}
+
+	return response
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccountPasswordThrottlesWrongPasswords(t *testing.T) {
+	mock := mockDatabase(t)
+
+	t.Cleanup(func() {
+		limiter.attempts = map[string]*loginAttempts{}
+	})
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := Config.signJWT(JWTPayload{Uid: 3, Tid: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handler without the permission-middleware
+	testApp := fiber.New()
+	testApp.Post("/", func(c *fiber.Ctx) error {
+		return postAccountPassword(c).send(c)
+	})
+
+	changePassword := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"current":"guessed password","new":"new password 1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+
+		resp, err := testApp.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.StatusCode
+	}
+
+	for range 2 {
+		mock.ExpectQuery(`FROM users WHERE uid = \?`).
+			WithArgs(3).
+			WillReturnRows(mockRows(User{Uid: 3, Name: "user", Role: roleMember, Password: newSecret(hash), Tid: 1}))
+	}
+
+	if status := changePassword(); status != http.StatusForbidden {
+		t.Fatalf("wrong password: got status %d, want %d", status, http.StatusForbidden)
+	}
+
+	// the next guess has to wait for the backoff, like a login
+	if status := changePassword(); status != http.StatusTooManyRequests {
+		t.Errorf("immediate second guess: got status %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
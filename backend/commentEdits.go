@@ -0,0 +1,120 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CommentEdit struct {
+	Cid    int       `json:"-"`
+	Text   string    `json:"text"`
+	Edited time.Time `json:"edited"`
+}
+
+// checks wether the user is allowed to edit or delete a comment:
+// admins always are, authors only during the edit-window
+func canModifyComment(c *fiber.Ctx, comment Comment, admin bool) (bool, error) {
+	if admin {
+		return true, nil
+	} else if uid, _, err := extractJWT(c); err != nil {
+		return false, err
+	} else {
+		return uid == comment.Uid && time.Since(comment.Created) <= Config.EditWindow, nil
+	}
+}
+
+func patchComments(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Text string `json:"text"`
+	})
+
+	if cid := c.QueryInt("cid", -1); cid < 0 {
+		logger.Info(`query doesn't include valid "cid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ text string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if comments, err := dbSelect[Comment]("comments", "cid = ? AND deleted IS NULL LIMIT 1", cid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(comments) != 1 {
+		logger.Sugar().Infof("comment %d doesn't exist", cid)
+		response.Status = fiber.StatusBadRequest
+
+		// only the author can edit a comment
+	} else if allowed, err := canModifyComment(c, comments[0], false); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+
+		// otherwise an edit would take the comment back into moderation
+	} else if comments[0].State == commentRejected {
+		logger.Sugar().Infof("comment %d was rejected and can't be edited", cid)
+		response.Status = fiber.StatusForbidden
+		response.Message = "rejected comments can't be edited"
+	} else if rules, _, err := getCommentRules(comments[0].Pid); err != nil {
+		logger.Sugar().Errorf("can't retrieve comment-rules: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else if !rules.validLength(body.Text) {
+		logger.Info("comment-length violates the comment-rules")
+		response.Status = fiber.StatusBadRequest
+	} else if text, state, filterResult := filterComment(body.Text, comments[0].Uid); filterResult.Action == filterReject {
+		response.Status = fiber.StatusBadRequest
+		response.Message = "comment was rejected by the filter"
+	} else if tx, err := db.Begin(); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		defer tx.Rollback()
+
+		// store the previous text in the edit-history
+		if _, err := tx.Exec("INSERT INTO edits (cid, text) VALUES (?, ?)", cid, comments[0].Text); err != nil {
+			logger.Sugar().Warnf("Writing comment-edit to database failed with error: %v", err.Error())
+			response.Status = fiber.StatusInternalServerError
+
+			// edited comments have to be moderated again
+		} else if _, err := tx.Exec("UPDATE comments SET text = ?, edited = NOW(), state = ? WHERE cid = ?", text, state, cid); err != nil {
+			logger.Sugar().Warnf("Updating comment failed with error: %v", err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else if err := tx.Commit(); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			response = getPostComments(c, comments[0].Pid)
+		}
+	}
+
+	return response
+}
+
+func getCommentsHistory(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if cid := c.QueryInt("cid", -1); cid < 0 {
+		logger.Info(`query doesn't include valid "cid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if comments, err := dbSelect[Comment]("comments", "cid = ? AND deleted IS NULL LIMIT 1", cid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(comments) != 1 {
+		logger.Sugar().Infof("comment %d doesn't exist", cid)
+		response.Status = fiber.StatusBadRequest
+	} else if admin, err := checkPermission(c, permComments); err != nil {
+		response.Status = fiber.StatusInternalServerError
+
+		// only the author and admins can see the history
+	} else if !admin && comments[0].Uid != uid {
+		response.Status = fiber.StatusForbidden
+	} else if edits, err := dbSelect[CommentEdit]("edits", "cid = ? ORDER BY edited", cid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = edits
+	}
+
+	return response
+}
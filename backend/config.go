@@ -4,16 +4,32 @@ import (
 	"bytes"
 	"fmt"
 	"io/fs"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/golang-jwt/jwt/v5"
 	"gopkg.in/yaml.v3"
 )
 
+// rules for the comments of a post
+type CommentRules struct {
+	// number of days, starting with the opening-day, during which a door accepts comments
+	OpenDays  int  `yaml:"open_days" json:"open_days"`
+	AllowPast bool `yaml:"allow_past" json:"allow_past"`
+	// maximum number of comments per user and door, 0 is unlimited
+	MaxPerUser int `yaml:"max_per_user" json:"max_per_user"`
+	MinLength  int `yaml:"min_length" json:"min_length"`
+	// maximum length of a comment, 0 is unlimited
+	MaxLength int `yaml:"max_length" json:"max_length"`
+	// who can see the comments: "public", "private" (only the author and admins) or "reveal" (public after the door closes)
+	Visibility string `yaml:"visibility" json:"visibility"`
+}
+
 type ConfigYaml struct {
 	LogLevel string `yaml:"log_level"`
 	Database struct {
@@ -25,22 +41,120 @@ type ConfigYaml struct {
 	} `yaml:"database"`
 	ClientSession struct {
 		JwtSignature string `yaml:"jwt_signature"`
-		Expire       string `yaml:"expire"`
+		// file with the signing-keys, created by "rotate-keys"
+		Keyset string `yaml:"keyset"`
+		// algorithm of the keys created by "rotate-keys"
+		Algorithm string `yaml:"algorithm"`
+		// lifetime of the access-tokens, they are renewed with the refresh-token
+		AccessExpire string `yaml:"access_expire"`
+		// sessions end if they aren't refreshed during this time
+		Expire string `yaml:"expire"`
+		// sessions end after this time, even if they are refreshed
+		MaxLifetime string `yaml:"max_lifetime"`
 	} `yaml:"client_session"`
 	Setup struct {
-		Days  int8   `yaml:"days"`
-		Start string `yaml:"start"`
+		Days   int8   `yaml:"days"`
+		Start  string `yaml:"start"`
+		Layout string `yaml:"layout"`
+		// name of the owner-account created by the setup
+		Owner string `yaml:"owner"`
 	} `yaml:"setup"`
+	Comments struct {
+		MaxDepth   int    `yaml:"max_depth"`
+		EditWindow string `yaml:"edit_window"`
+		Moderation bool   `yaml:"moderation"`
+		Filters    struct {
+			Words struct {
+				List   []string `yaml:"list"`
+				Action string   `yaml:"action"`
+			} `yaml:"words"`
+			Links struct {
+				Max    int    `yaml:"max"`
+				Action string `yaml:"action"`
+			} `yaml:"links"`
+			MaxLength int `yaml:"max_length"`
+		} `yaml:"filters"`
+		CommentRules `yaml:",inline"`
+	} `yaml:"comments"`
+	Trash struct {
+		Retention string `yaml:"retention"`
+	} `yaml:"trash"`
+	Invites struct {
+		// default validity of invitation-links
+		Expire string `yaml:"expire"`
+	} `yaml:"invites"`
+	Login struct {
+		// failed attempts after which an account is locked
+		MaxFailures int    `yaml:"max_failures"`
+		Lockout     string `yaml:"lockout"`
+		Backoff     string `yaml:"backoff"`
+		MaxBackoff  string `yaml:"max_backoff"`
+	} `yaml:"login"`
+	TwoFactor struct {
+		// issuer shown in the authenticator-apps
+		Issuer string `yaml:"issuer"`
+		// users with administrative roles have to enable two-factor-authentication
+		RequireForAdmins bool `yaml:"require_for_admins"`
+	} `yaml:"two_factor"`
+	Passwords struct {
+		MinLength     int  `yaml:"min_length"`
+		RequireLetter bool `yaml:"require_letter"`
+		RequireDigit  bool `yaml:"require_digit"`
+	} `yaml:"passwords"`
+	Oidc struct {
+		// login with an external identity-provider
+		Enabled      bool   `yaml:"enabled"`
+		Issuer       string `yaml:"issuer"`
+		ClientId     string `yaml:"client_id"`
+		ClientSecret string `yaml:"client_secret"`
+		// address of the "/api/oidc/callback"-endpoint as registered at the identity-provider
+		RedirectUrl string   `yaml:"redirect_url"`
+		Scopes      []string `yaml:"scopes"`
+		// claim used as name for provisioned users
+		NameClaim string `yaml:"name_claim"`
+		// link users with the same verified email-address on their first login
+		LinkByEmail bool `yaml:"link_by_email"`
+		// unknown users are created with this role, empty disables the provisioning
+		Provision Role `yaml:"provision"`
+	} `yaml:"oidc"`
+	MagicLink struct {
+		// login with a link sent by email, requires "smtp"
+		Enabled bool   `yaml:"enabled"`
+		Expire  string `yaml:"expire"`
+	} `yaml:"magic_link"`
+	Smtp struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+		// without user, no authentication is used
+		User     string `yaml:"user"`
+		Password string `yaml:"password"`
+		From     string `yaml:"from"`
+	} `yaml:"smtp"`
 	Server struct {
 		Port      int    `yaml:"port"`
 		UploadDir string `yaml:"upload_dir"`
+		// public address of the calendar, used for links to it
+		Url string `yaml:"url"`
+		// header, in which a reverse-proxy passes the address of the client, e.g. "X-Real-IP"
+		ProxyHeader string `yaml:"proxy_header"`
+		// addresses or ranges of the reverse-proxies, whose header is trusted
+		TrustedProxies []string `yaml:"trusted_proxies"`
 	} `yaml:"server"`
 }
 
 type ConfigStruct struct {
 	ConfigYaml
-	SessionExpire time.Duration
-	UploadDirSys  fs.FS
+	SessionExpire   time.Duration
+	AccessExpire    time.Duration
+	MaxLifetime     time.Duration
+	EditWindow      time.Duration
+	TrashRetention  time.Duration
+	InviteExpire    time.Duration
+	LoginLockout    time.Duration
+	LoginBackoff    time.Duration
+	MaxBackoff      time.Duration
+	MagicLinkExpire time.Duration
+	UploadDirSys    fs.FS
 }
 
 var Config ConfigStruct
@@ -51,6 +165,10 @@ type Payload struct {
 }
 
 func (config ConfigStruct) signJWT(val any) (string, error) {
+	return config.signJWTExpiring(val, config.AccessExpire)
+}
+
+func (config ConfigStruct) signJWTExpiring(val any, expire time.Duration) (string, error) {
 	valMap, err := strucToMap(val)
 
 	if err != nil {
@@ -59,15 +177,13 @@ func (config ConfigStruct) signJWT(val any) (string, error) {
 
 	payload := Payload{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(Config.SessionExpire)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expire)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 		CustomClaims: valMap,
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, payload)
-
-	return t.SignedString([]byte(Config.ClientSession.JwtSignature))
+	return keys.sign(payload)
 }
 
 func (config ConfigStruct) sanitizeUploadDir(pth string) (string, error) {
@@ -107,6 +223,31 @@ func (config ConfigStruct) getUploadDir(pth string) (string, error) {
 func loadConfig() ConfigStruct {
 	config := ConfigYaml{}
 
+	// defaults for values, which might be missing in the config-file
+	config.ClientSession.Keyset = "keyset.json"
+	config.ClientSession.Algorithm = "HS256"
+	config.ClientSession.AccessExpire = "15m"
+	config.ClientSession.MaxLifetime = "720h"
+	config.Setup.Owner = "admin"
+	config.Comments.MaxDepth = 3
+	config.Comments.OpenDays = 1
+	config.Comments.MaxPerUser = 1
+	config.Comments.MinLength = 1
+	config.Comments.Visibility = "public"
+	config.Comments.EditWindow = "15m"
+	config.Trash.Retention = "720h"
+	config.Invites.Expire = "168h"
+	config.Login.MaxFailures = 5
+	config.Login.Lockout = "15m"
+	config.Login.Backoff = "1s"
+	config.Login.MaxBackoff = "5m"
+	config.Passwords.MinLength = 8
+	config.TwoFactor.Issuer = "advent-server"
+	config.Oidc.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	config.Oidc.NameClaim = "preferred_username"
+	config.MagicLink.Expire = "15m"
+	config.Smtp.Port = 587
+
 	yamlFile, err := os.ReadFile("config.yaml")
 	if err != nil {
 		logger.Sugar().Errorf("Error opening config-file: %v", err)
@@ -129,13 +270,125 @@ func loadConfig() ConfigStruct {
 		os.Exit(1)
 	}
 
+	accessExpire, err := time.ParseDuration(config.ClientSession.AccessExpire)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "client_session.access_expire": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	maxLifetime, err := time.ParseDuration(config.ClientSession.MaxLifetime)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "client_session.max_lifetime": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	editWindow, err := time.ParseDuration(config.Comments.EditWindow)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "comments.edit_window": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	trashRetention, err := time.ParseDuration(config.Trash.Retention)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "trash.retention": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	inviteExpire, err := time.ParseDuration(config.Invites.Expire)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "invites.expire": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	loginLockout, err := time.ParseDuration(config.Login.Lockout)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "login.lockout": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	loginBackoff, err := time.ParseDuration(config.Login.Backoff)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "login.backoff": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	maxBackoff, err := time.ParseDuration(config.Login.MaxBackoff)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "login.max_backoff": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	magicLinkExpire, err := time.ParseDuration(config.MagicLink.Expire)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Error Parsing "magic_link.expire": %v`, err.Error())
+		os.Exit(1)
+	}
+
+	if err := config.Comments.CommentRules.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, `Error parsing "comments": %v`, err)
+		os.Exit(1)
+	}
+
+	if !validVisibility(config.Comments.Visibility) {
+		fmt.Fprintf(os.Stderr, `Error parsing "comments.visibility": unknown visibility %q`, config.Comments.Visibility)
+		os.Exit(1)
+	}
+
+	switch config.ClientSession.Algorithm {
+	case "HS256", "EdDSA", "RS256":
+	default:
+		fmt.Fprintf(os.Stderr, `Error parsing "client_session.algorithm": unknown algorithm %q`, config.ClientSession.Algorithm)
+		os.Exit(1)
+	}
+
+	if config.Oidc.Provision != "" && (!validRole(config.Oidc.Provision) || config.Oidc.Provision == roleOwner) {
+		fmt.Fprintf(os.Stderr, `Error parsing "oidc.provision": invalid role %q`, config.Oidc.Provision)
+		os.Exit(1)
+	}
+
+	for _, proxy := range config.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			fmt.Fprintf(os.Stderr, `Error parsing "server.trusted_proxies": invalid address %q`, proxy)
+			os.Exit(1)
+		}
+	}
+
+	switch config.Setup.Layout {
+	case "":
+		config.Setup.Layout = "user"
+	case "user", "calendar":
+	default:
+		fmt.Fprintf(os.Stderr, `Error parsing "setup.layout": unknown layout %q`, config.Setup.Layout)
+		os.Exit(1)
+	}
+
 	return ConfigStruct{
-		ConfigYaml:    config,
-		SessionExpire: duration,
-		UploadDirSys:  os.DirFS(config.Server.UploadDir),
+		ConfigYaml:      config,
+		SessionExpire:   duration,
+		AccessExpire:    accessExpire,
+		MaxLifetime:     maxLifetime,
+		EditWindow:      editWindow,
+		TrashRetention:  trashRetention,
+		InviteExpire:    inviteExpire,
+		LoginLockout:    loginLockout,
+		LoginBackoff:    loginBackoff,
+		MaxBackoff:      maxBackoff,
+		MagicLinkExpire: magicLinkExpire,
+		UploadDirSys:    os.DirFS(config.Server.UploadDir),
 	}
 }
 
 func init() {
 	Config = loadConfig()
+
+	app = newApp()
 }
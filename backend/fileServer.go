@@ -414,12 +414,14 @@ func init() {
 
 			var response responseMessage
 
-			// check wether the session-cookie is valid and the user is an admin
-			if admin, err := checkAdmin(c); err != nil {
+			// check wether the session-cookie is valid and the user can manage files
+			if allowed, err := checkPermission(c, permFiles); err == errInvalidSession {
+				response.Status = fiber.StatusUnauthorized
+			} else if err != nil {
 				response.Status = fiber.StatusInternalServerError
 
-				logger.Sugar().Errorf("can't check for admin: %v", err)
-			} else if !admin {
+				logger.Sugar().Errorf("can't check for permission: %v", err)
+			} else if !allowed {
 				response.Status = fiber.StatusUnauthorized
 
 				// check for a valid query
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+type FilterAction int
+
+// actions ordered by severity
+const (
+	filterAccept FilterAction = iota
+	filterMask
+	filterFlag
+	filterReject
+)
+
+func (action FilterAction) String() string {
+	switch action {
+	case filterMask:
+		return "mask"
+	case filterFlag:
+		return "flag"
+	case filterReject:
+		return "reject"
+	default:
+		return "accept"
+	}
+}
+
+func parseFilterAction(s string) (FilterAction, error) {
+	switch s {
+	case "mask":
+		return filterMask, nil
+	case "flag":
+		return filterFlag, nil
+	case "reject":
+		return filterReject, nil
+	default:
+		return filterAccept, fmt.Errorf("unknown filter-action %q", s)
+	}
+}
+
+type FilterResult struct {
+	Action FilterAction
+	// text of the comment, modified if the action is filterMask
+	Text   string
+	Reason string
+}
+
+// a CommentFilter checks the text of a comment before it is stored
+type CommentFilter interface {
+	Name() string
+	Filter(text string) FilterResult
+}
+
+type FilterPipeline []CommentFilter
+
+// runs the text through all filters, the most severe action wins
+func (pipeline FilterPipeline) run(text string, uid int) FilterResult {
+	result := FilterResult{
+		Action: filterAccept,
+		Text:   text,
+	}
+
+	for _, filter := range pipeline {
+		filterResult := filter.Filter(result.Text)
+
+		if filterResult.Action == filterAccept {
+			continue
+		}
+
+		logger.Sugar().Infof("comment-filter %q decided %q for comment of uid = %d: %s", filter.Name(), filterResult.Action, uid, filterResult.Reason)
+
+		if filterResult.Action == filterMask {
+			result.Text = filterResult.Text
+		}
+
+		if filterResult.Action > result.Action {
+			result.Action = filterResult.Action
+			result.Reason = filterResult.Reason
+		}
+
+		// no need to check any further
+		if result.Action == filterReject {
+			break
+		}
+	}
+
+	return result
+}
+
+// filters a list of words and phrases, masking replaces them with asterisks
+type WordFilter struct {
+	// the lower-case words of the entries, so phrases match regardless of the separators between their words
+	phrases [][]string
+	action  FilterAction
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+func newWordFilter(entries []string, action FilterAction) (WordFilter, error) {
+	filter := WordFilter{
+		action: action,
+	}
+
+	for _, entry := range entries {
+		if words := wordPattern.FindAllString(strings.ToLower(entry), -1); len(words) == 0 {
+			return WordFilter{}, fmt.Errorf("entry %q contains no letters or digits", entry)
+		} else {
+			filter.phrases = append(filter.phrases, words)
+		}
+	}
+
+	return filter, nil
+}
+
+func (filter WordFilter) Name() string {
+	return "words"
+}
+
+// returns the number of words of the phrase starting at the word, 0 if none matches
+func (filter WordFilter) match(words []string, start int) int {
+	for _, phrase := range filter.phrases {
+		if start+len(phrase) <= len(words) && slices.Equal(phrase, words[start:start+len(phrase)]) {
+			return len(phrase)
+		}
+	}
+
+	return 0
+}
+
+func (filter WordFilter) Filter(text string) FilterResult {
+	indices := wordPattern.FindAllStringIndex(text, -1)
+	words := make([]string, len(indices))
+
+	for ii, index := range indices {
+		words[ii] = strings.ToLower(text[index[0]:index[1]])
+	}
+
+	count := 0
+	var masked strings.Builder
+	last := 0
+
+	for ii := 0; ii < len(words); {
+		length := filter.match(words, ii)
+
+		if length == 0 {
+			ii++
+
+			continue
+		}
+
+		count++
+
+		// only the words are masked, the separators of a phrase are kept
+		for _, index := range indices[ii : ii+length] {
+			masked.WriteString(text[last:index[0]])
+			masked.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[index[0]:index[1]])))
+			last = index[1]
+		}
+
+		ii += length
+	}
+
+	if count == 0 {
+		return FilterResult{Action: filterAccept, Text: text}
+	}
+
+	result := FilterResult{
+		Action: filter.action,
+		Text:   text,
+		Reason: fmt.Sprintf("contains %d blocked words", count),
+	}
+
+	if filter.action == filterMask {
+		masked.WriteString(text[last:])
+
+		result.Text = masked.String()
+	}
+
+	return result
+}
+
+// limits the number of links in a comment
+type LinkFilter struct {
+	max    int
+	action FilterAction
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+func (filter LinkFilter) Name() string {
+	return "links"
+}
+
+func (filter LinkFilter) Filter(text string) FilterResult {
+	if count := len(linkPattern.FindAllString(text, -1)); count > filter.max {
+		return FilterResult{
+			Action: filter.action,
+			Text:   text,
+			Reason: fmt.Sprintf("contains %d links, only %d allowed", count, filter.max),
+		}
+	} else {
+		return FilterResult{Action: filterAccept, Text: text}
+	}
+}
+
+// rejects comments exceeding a maximum length
+type LengthFilter struct {
+	max int
+}
+
+func (filter LengthFilter) Name() string {
+	return "length"
+}
+
+func (filter LengthFilter) Filter(text string) FilterResult {
+	if length := utf8.RuneCountInString(text); length > filter.max {
+		return FilterResult{
+			Action: filterReject,
+			Text:   text,
+			Reason: fmt.Sprintf("has %d characters, only %d allowed", length, filter.max),
+		}
+	} else {
+		return FilterResult{Action: filterAccept, Text: text}
+	}
+}
+
+var commentFilters FilterPipeline
+
+// runs a comment through the filters and returns the text and the moderation-state to store
+func filterComment(text string, uid int) (string, string, FilterResult) {
+	result := commentFilters.run(text, uid)
+
+	if result.Action == filterFlag {
+		return result.Text, commentPending, result
+	} else {
+		return result.Text, newCommentState(), result
+	}
+}
+
+func init() {
+	filters := Config.Comments.Filters
+
+	if filters.MaxLength > 0 {
+		commentFilters = append(commentFilters, LengthFilter{max: filters.MaxLength})
+	}
+
+	if len(filters.Words.List) > 0 {
+		if action, err := parseFilterAction(filters.Words.Action); err != nil {
+			fmt.Fprintf(os.Stderr, `Error parsing "comments.filters.words.action": %v`, err)
+			os.Exit(1)
+		} else if filter, err := newWordFilter(filters.Words.List, action); err != nil {
+			fmt.Fprintf(os.Stderr, `Error parsing "comments.filters.words.list": %v`, err)
+			os.Exit(1)
+		} else {
+			commentFilters = append(commentFilters, filter)
+		}
+	}
+
+	if filters.Links.Action != "" {
+		if action, err := parseFilterAction(filters.Links.Action); err != nil || action == filterMask {
+			fmt.Fprintf(os.Stderr, `Error parsing "comments.filters.links.action": invalid action %q`, filters.Links.Action)
+			os.Exit(1)
+		} else {
+			commentFilters = append(commentFilters, LinkFilter{max: filters.Links.Max, action: action})
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWordFilterMasksWordsAndPhrases(t *testing.T) {
+	filter, err := newWordFilter([]string{"Spam", "buy now", "click-here"}, filterMask)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text    string
+		masked  string
+		blocked bool
+	}{
+		{"no problem here", "no problem here", false},
+		{"SPAM and spam", "**** and ****", true},
+		// only whole words match
+		{"spammer", "spammer", false},
+		{"please buy now!", "please *** ***!", true},
+		{"buy-now or Buy  Now", "***-*** or ***  ***", true},
+		{"click here", "***** ****", true},
+		{"buy later", "buy later", false},
+	}
+
+	for _, test := range tests {
+		result := filter.Filter(test.text)
+
+		if result.Text != test.masked {
+			t.Errorf("%q: got %q, want %q", test.text, result.Text, test.masked)
+		} else if (result.Action == filterMask) != test.blocked {
+			t.Errorf("%q: got action %q", test.text, result.Action)
+		}
+	}
+}
+
+func TestWordFilterRejectsEntriesWithoutWords(t *testing.T) {
+	if _, err := newWordFilter([]string{"spam", "!!!"}, filterReject); err == nil {
+		t.Error("entry without letters or digits was accepted")
+	}
+}
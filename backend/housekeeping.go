@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+)
+
+// interval in which expired rows are removed
+const housekeepingInterval = time.Hour
+
+// a delete-command with its arguments
+type housekeepingCommand struct {
+	query string
+	args  []any
+}
+
+// permanently deletes rows, which expired on their own and can't be used anymore
+func purgeExpired() error {
+	now := time.Now()
+
+	commands := []housekeepingCommand{
+		{"DELETE FROM invites WHERE expires < ?", []any{now}},
+		{"DELETE FROM sessions WHERE revoked IS NOT NULL OR created < ? OR refreshed < ?", []any{now.Add(-Config.MaxLifetime), now.Add(-Config.SessionExpire)}},
+		{"DELETE FROM magic_links WHERE expires < ?", []any{now}},
+	}
+
+	for _, cmd := range commands {
+		if res, err := db.Exec(cmd.query, cmd.args...); err != nil {
+			return err
+		} else if count, err := res.RowsAffected(); err == nil && count > 0 {
+			logger.Sugar().Infof("purged %d expired rows: %q", count, cmd.query)
+		}
+	}
+
+	return nil
+}
+
+func purgeExpiredPeriodically() {
+	for {
+		if err := purgeExpired(); err != nil {
+			logger.Sugar().Errorf("can't purge expired rows: %v", err)
+		}
+
+		time.Sleep(housekeepingInterval)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+)
+
+// minimum length of the generated passwords, the password-policy can require longer ones
+const importPasswordLength = 12
+
+// generated passwords, that violate the password-policy, are discarded; this bounds the attempts for unsatisfiable policies
+const importPasswordAttempts = 100
+
+// characters of the generated passwords, without easily confused ones like "0" and "O"
+const importPasswordChars = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+type ImportUser struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// login-data of an imported user
+type Credential struct {
+	Uid      int64  `json:"uid"`
+	Name     string `json:"name"`
+	Role     Role   `json:"role"`
+	Password string `json:"password"`
+}
+
+// creates a random password with a cryptographically secure generator
+func generatePassword(length int) (string, error) {
+	password := make([]byte, length)
+	max := big.NewInt(int64(len(importPasswordChars)))
+
+	for ii := range password {
+		if n, err := rand.Int(rand.Reader, max); err != nil {
+			return "", err
+		} else {
+			password[ii] = importPasswordChars[n.Int64()]
+		}
+	}
+
+	return string(password), nil
+}
+
+// creates a random password, that satisfies the password-policy
+func generatePolicyPassword() (string, error) {
+	length := max(importPasswordLength, Config.Passwords.MinLength)
+
+	for range importPasswordAttempts {
+		if password, err := generatePassword(length); err != nil {
+			return "", err
+		} else if validPassword(password) == nil {
+			return password, nil
+		}
+	}
+
+	return "", fmt.Errorf("can't generate a password with %d characters satisfying the password-policy", length)
+}
+
+// parses the users either from a JSON-array or a CSV-file with the columns "name" and optionally "role"
+func parseImportUsers(data []byte, format string) ([]ImportUser, error) {
+	users := []ImportUser{}
+
+	switch format {
+	case "json":
+		if err := json.Unmarshal(data, &users); err != nil {
+			return nil, err
+		}
+	case "csv":
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+
+		columns := map[string]int{}
+
+		for line := 0; ; line++ {
+			record, err := reader.Read()
+
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			// the first line contains the column-names
+			if line == 0 {
+				for ii, column := range record {
+					columns[strings.ToLower(strings.TrimSpace(column))] = ii
+				}
+
+				if _, ok := columns["name"]; !ok {
+					return nil, fmt.Errorf(`csv doesn't have a "name"-column`)
+				}
+
+				continue
+			}
+
+			var user ImportUser
+
+			if ii := columns["name"]; ii < len(record) {
+				user.Name = record[ii]
+			}
+
+			if ii, ok := columns["role"]; ok && ii < len(record) {
+				user.Role = Role(strings.TrimSpace(record[ii]))
+			}
+
+			users = append(users, user)
+		}
+	default:
+		return nil, fmt.Errorf("unknown import-format %q", format)
+	}
+
+	// validate the users
+	names := map[string]bool{}
+
+	for ii := range users {
+		users[ii].Name = strings.TrimSpace(users[ii].Name)
+
+		if users[ii].Role == "" {
+			users[ii].Role = roleMember
+		}
+
+		if users[ii].Name == "" || utf8.RuneCountInString(users[ii].Name) > maxUserNameLength {
+			return nil, fmt.Errorf("invalid name %q in entry %d", users[ii].Name, ii+1)
+		} else if names[users[ii].Name] {
+			return nil, fmt.Errorf("duplicate name %q", users[ii].Name)
+		} else if !validRole(users[ii].Role) || users[ii].Role == roleOwner {
+			return nil, fmt.Errorf("invalid role %q for %q", users[ii].Role, users[ii].Name)
+		}
+
+		names[users[ii].Name] = true
+	}
+
+	return users, nil
+}
+
+// creates the users with generated passwords, either all or none of them
+func importUsers(users []ImportUser) ([]Credential, error) {
+	credentials := make([]Credential, len(users))
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	for ii, user := range users {
+		var count int
+
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE name = ?", user.Name).Scan(&count); err != nil {
+			return nil, err
+		} else if count != 0 {
+			return nil, fmt.Errorf("user with name %q already exists", user.Name)
+		}
+
+		password, err := generatePolicyPassword()
+		if err != nil {
+			return nil, err
+		}
+
+		hashedPassword, err := hashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+
+		if res, err := tx.Exec("INSERT INTO users (name, role, password) VALUES (?, ?, ?)", user.Name, user.Role, newSecret(hashedPassword)); err != nil {
+			return nil, err
+		} else if uid, err := res.LastInsertId(); err != nil {
+			return nil, err
+		} else {
+			credentials[ii] = Credential{
+				Uid:      uid,
+				Name:     user.Name,
+				Role:     user.Role,
+				Password: password,
+			}
+		}
+	}
+
+	return credentials, tx.Commit()
+}
+
+// link for logging in, the credentials are in the fragment so they aren't sent to the server
+func loginLink(credential Credential) string {
+	fragment := url.Values{}
+	fragment.Set("user", credential.Name)
+	fragment.Set("password", credential.Password)
+
+	return strings.TrimSuffix(Config.Server.Url, "/") + "/#" + fragment.Encode()
+}
+
+var credentialSheetTemplate = template.Must(template.New("sheet").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Login-data</title>
+<style>
+	body { font-family: sans-serif; }
+	.credential { display: inline-flex; align-items: center; gap: 1em; width: 45%; margin: 0.5em; padding: 0.5em; border: 1px dashed gray; page-break-inside: avoid; }
+	.credential img { width: 8em; height: 8em; }
+	.password { font-family: monospace; font-size: 1.2em; }
+</style>
+</head>
+<body>
+{{- range . }}
+<div class="credential">
+	<img src="data:image/png;base64,{{ .QR }}" alt="login-code">
+	<div>
+		<div>{{ .Url }}</div>
+		<div>name: <b>{{ .Name }}</b></div>
+		<div>password: <span class="password">{{ .Password }}</span></div>
+	</div>
+</div>
+{{- end }}
+</body>
+</html>
+`))
+
+// creates a printable html-page with the login-data and a qr-code for every user
+func credentialSheet(credentials []Credential) ([]byte, error) {
+	type sheetEntry struct {
+		Credential
+		Url string
+		QR  string
+	}
+
+	entries := make([]sheetEntry, len(credentials))
+
+	for ii, credential := range credentials {
+		if png, err := qrcode.Encode(loginLink(credential), qrcode.Medium, 256); err != nil {
+			return nil, err
+		} else {
+			entries[ii] = sheetEntry{
+				Credential: credential,
+				Url:        Config.Server.Url,
+				QR:         base64.StdEncoding.EncodeToString(png),
+			}
+		}
+	}
+
+	var sheet bytes.Buffer
+
+	if err := credentialSheetTemplate.Execute(&sheet, entries); err != nil {
+		return nil, err
+	}
+
+	return sheet.Bytes(), nil
+}
+
+// imports users from the body, "format" is "json" or "csv"; with "sheet=true" the credential-sheet is sent
+func postUsersImport(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if users, err := parseImportUsers(c.Body(), c.Query("format", "json")); err != nil {
+		logger.Info(err.Error())
+		response.Status = fiber.StatusBadRequest
+		response.Message = err.Error()
+	} else if credentials, err := importUsers(users); err != nil {
+		logger.Sugar().Warnf("can't import users: %v", err)
+		response.Status = fiber.StatusConflict
+		response.Message = err.Error()
+	} else {
+		logger.Sugar().Infof("imported %d users", len(credentials))
+
+		if c.QueryBool("sheet") {
+			if sheet, err := credentialSheet(credentials); err != nil {
+				logger.Sugar().Errorf("can't create credential-sheet: %v", err)
+				response.Status = fiber.StatusInternalServerError
+			} else {
+				c.Type("html")
+				response.Buffer = sheet
+			}
+		} else {
+			response.Data = credentials
+		}
+	}
+
+	return response
+}
+
+// command-line import: "import-users <file.csv|file.json> [sheet.html]"
+func runImportUsers(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: import-users <file.csv|file.json> [sheet.html]")
+	}
+
+	format := "json"
+
+	if strings.HasSuffix(strings.ToLower(args[0]), ".csv") {
+		format = "csv"
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	users, err := parseImportUsers(data, format)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := importUsers(users)
+	if err != nil {
+		return err
+	}
+
+	for _, credential := range credentials {
+		fmt.Printf("%s\t%s\t%s\n", credential.Name, credential.Role, credential.Password)
+	}
+
+	if len(args) == 2 {
+		if sheet, err := credentialSheet(credentials); err != nil {
+			return err
+		} else if err := os.WriteFile(args[1], sheet, 0o600); err != nil {
+			return err
+		}
+
+		fmt.Printf("wrote credential-sheet to %q\n", args[1])
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGeneratePolicyPassword(t *testing.T) {
+	previous := Config.Passwords
+
+	t.Cleanup(func() {
+		Config.Passwords = previous
+	})
+
+	Config.Passwords.MinLength = 20
+	Config.Passwords.RequireLetter = true
+	Config.Passwords.RequireDigit = true
+
+	// without checking the policy, about every sixth password lacked a digit
+	for range 200 {
+		if password, err := generatePolicyPassword(); err != nil {
+			t.Fatal(err)
+		} else if len(password) != 20 {
+			t.Fatalf("got password of length %d, want 20", len(password))
+		} else if err := validPassword(password); err != nil {
+			t.Fatalf("password %q violates the policy: %v", password, err)
+		}
+	}
+}
+
+func TestGeneratePolicyPasswordMinimumLength(t *testing.T) {
+	previous := Config.Passwords
+
+	t.Cleanup(func() {
+		Config.Passwords = previous
+	})
+
+	Config.Passwords.MinLength = 4
+
+	if password, err := generatePolicyPassword(); err != nil {
+		t.Fatal(err)
+	} else if len(password) != importPasswordLength {
+		t.Errorf("got password of length %d, want %d", len(password), importPasswordLength)
+	}
+}
+
+func TestGeneratePolicyPasswordUnsatisfiable(t *testing.T) {
+	previous := Config.Passwords
+
+	t.Cleanup(func() {
+		Config.Passwords = previous
+	})
+
+	// bcrypt only uses 72 bytes, so no password satisfies the policy
+	Config.Passwords.MinLength = 100
+
+	if _, err := generatePolicyPassword(); err == nil {
+		t.Error("generated a password for an unsatisfiable policy")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maximum length of user-names chosen during the registration
+const maxUserNameLength = 64
+
+type Invite struct {
+	Iid     int        `json:"iid"`
+	Role    Role       `json:"role"`
+	Creator int        `json:"creator"`
+	Created time.Time  `json:"created"`
+	Expires time.Time  `json:"expires"`
+	Used    *time.Time `json:"used"`
+	Uid     *int       `json:"uid"`
+}
+
+// only the hash of the invite-tokens is stored in the database
+func hashInviteToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+
+	return hash[:]
+}
+
+func getInvites(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if invites, err := dbSelect[Invite]("invites", "TRUE ORDER BY iid DESC"); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = invites
+	}
+
+	return response
+}
+
+// creates a single-use invitation, the token is only sent once
+func postInvites(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Role   Role   `json:"role"`
+		Expire string `json:"expire"`
+	})
+
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ role string; expire string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else {
+		expire := Config.InviteExpire
+
+		if body.Role == "" {
+			body.Role = roleMember
+		}
+
+		if body.Expire != "" {
+			expire, err = time.ParseDuration(body.Expire)
+		}
+
+		random := make([]byte, 32)
+
+		if err != nil || expire <= 0 {
+			logger.Sugar().Infof("invalid expire %q", body.Expire)
+			response.Status = fiber.StatusBadRequest
+
+			// there is only one owner
+		} else if !validRole(body.Role) || body.Role == roleOwner {
+			logger.Sugar().Infof("invalid role %q", body.Role)
+			response.Status = fiber.StatusBadRequest
+		} else if _, err := rand.Read(random); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			token := base64.RawURLEncoding.EncodeToString(random)
+			expires := time.Now().Add(expire)
+
+			if res, err := db.Exec("INSERT INTO invites (token, role, creator, expires) VALUES (?, ?, ?, ?)", hashInviteToken(token), body.Role, uid, expires); err != nil {
+				logger.Sugar().Error(err.Error())
+				response.Status = fiber.StatusInternalServerError
+			} else if iid, err := res.LastInsertId(); err != nil {
+				logger.Sugar().Error(err.Error())
+				response.Status = fiber.StatusInternalServerError
+			} else {
+				logger.Sugar().Infof("user %d created invite %d for role %q", uid, iid, body.Role)
+
+				response.Data = struct {
+					Iid     int64     `json:"iid"`
+					Token   string    `json:"token"`
+					Expires time.Time `json:"expires"`
+				}{
+					Iid:     iid,
+					Token:   token,
+					Expires: expires,
+				}
+			}
+		}
+	}
+
+	return response
+}
+
+func deleteInvites(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if iid := c.QueryInt("iid", -1); iid < 0 {
+		logger.Info(`query doesn't include valid "iid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if _, err := db.Exec("DELETE FROM invites WHERE iid = ? AND used IS NULL", iid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response = getInvites(c)
+	}
+
+	return response
+}
+
+// public endpoint, where invitees create their account
+func handleRegister(c *fiber.Ctx) error {
+	var response responseMessage
+
+	body := new(struct {
+		Token    string `json:"token"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	})
+
+	if err := c.BodyParser(body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ token string; name string; password string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if body.Name = strings.TrimSpace(body.Name); body.Name == "" || utf8.RuneCountInString(body.Name) > maxUserNameLength {
+		logger.Info("invalid user-name")
+		response.Status = fiber.StatusBadRequest
+		response.Message = "invalid name"
+	} else if err := validPassword(body.Password); err != nil {
+		response.Status = fiber.StatusBadRequest
+		response.Message = err.Error()
+	} else if invites, err := dbSelect[Invite]("invites", "token = ? AND used IS NULL AND expires > ? LIMIT 1", hashInviteToken(body.Token), time.Now()); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(invites) != 1 {
+		logger.Sugar().Infof("registration from %s with invalid invite", c.IP())
+		response.Status = fiber.StatusForbidden
+		response.Message = "invalid or expired invite"
+	} else if userCount, err := dbCount("users", struct{ Name string }{Name: body.Name}); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if userCount != 0 {
+		logger.Sugar().Debugf("user with name %q already exists", body.Name)
+		response.Status = fiber.StatusConflict
+	} else if hashedPassword, err := hashPassword(body.Password); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response = registerInvitee(c, invites[0], body.Name, hashedPassword)
+	}
+
+	return response.send(c)
+}
+
+// creates the user of an invite and logs them in
+func registerInvitee(c *fiber.Ctx, invite Invite, name string, hashedPassword []byte) responseMessage {
+	var response responseMessage
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+
+		return response
+	}
+
+	defer tx.Rollback()
+
+	if res, err := tx.Exec("INSERT INTO users (name, role, password) VALUES (?, ?, ?)", name, invite.Role, newSecret(hashedPassword)); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if uid, err := res.LastInsertId(); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+
+		// the invite can only be used once, even with concurrent registrations
+	} else if res, err := tx.Exec("UPDATE invites SET used = ?, uid = ? WHERE iid = ? AND used IS NULL", time.Now(), uid, invite.Iid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if count, err := res.RowsAffected(); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if count != 1 {
+		response.Status = fiber.StatusForbidden
+		response.Message = "invalid or expired invite"
+	} else if err := tx.Commit(); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if users, err := dbSelect[User]("users", "uid = ? LIMIT 1", uid); err != nil || len(users) != 1 {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		logger.Sugar().Infof("user %q registered with invite %d", name, invite.Iid)
+
+		response = completeLogin(c, users[0])
+	}
+
+	return response
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// kid of the "client_session.jwt_signature", which was used before the keyset existed
+const legacyKid = "legacy"
+
+// interval in which the keyset-file is checked for changes
+const keysetReloadInterval = time.Minute
+
+// a key as it is stored in the keyset-file
+type KeysetKey struct {
+	Kid       string `json:"kid"`
+	Algorithm string `json:"alg"`
+	// base64-encoded secret for "HS256", PEM-encoded private key for "EdDSA" and "RS256"
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+	// time when the key was replaced, afterwards it is only used for verifying tokens
+	Retired *time.Time `json:"retired,omitempty"`
+}
+
+// the first key signs new tokens, the others only verify existing ones
+type KeysetFile struct {
+	Keys []KeysetKey `json:"keys"`
+}
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   any
+	verify any
+}
+
+type keyset struct {
+	mutex    sync.RWMutex
+	current  signingKey
+	keys     map[string]signingKey
+	modified time.Time
+}
+
+var keys keyset
+
+// parses the key-material for its algorithm
+func (key KeysetKey) parse() (signingKey, error) {
+	parsed := signingKey{
+		kid: key.Kid,
+	}
+
+	switch key.Algorithm {
+	case "HS256":
+		secret, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil {
+			return parsed, err
+		}
+
+		parsed.method = jwt.SigningMethodHS256
+		parsed.sign = secret
+		parsed.verify = secret
+	case "EdDSA":
+		private, err := jwt.ParseEdPrivateKeyFromPEM([]byte(key.Key))
+		if err != nil {
+			return parsed, err
+		}
+
+		parsed.method = jwt.SigningMethodEdDSA
+		parsed.sign = private
+		parsed.verify = private.(ed25519.PrivateKey).Public()
+	case "RS256":
+		private, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(key.Key))
+		if err != nil {
+			return parsed, err
+		}
+
+		parsed.method = jwt.SigningMethodRS256
+		parsed.sign = private
+		parsed.verify = &private.PublicKey
+	default:
+		return parsed, fmt.Errorf("unknown algorithm %q", key.Algorithm)
+	}
+
+	return parsed, nil
+}
+
+// creates a new key with random key-material
+func generateKey(algorithm string) (KeysetKey, error) {
+	kid := make([]byte, 12)
+
+	if _, err := rand.Read(kid); err != nil {
+		return KeysetKey{}, err
+	}
+
+	key := KeysetKey{
+		Kid:       base64.RawURLEncoding.EncodeToString(kid),
+		Algorithm: algorithm,
+		Created:   time.Now(),
+	}
+
+	var private any
+
+	switch algorithm {
+	case "HS256":
+		secret := make([]byte, 64)
+
+		if _, err := rand.Read(secret); err != nil {
+			return key, err
+		}
+
+		key.Key = base64.StdEncoding.EncodeToString(secret)
+
+		return key, nil
+	case "EdDSA":
+		if _, edKey, err := ed25519.GenerateKey(rand.Reader); err != nil {
+			return key, err
+		} else {
+			private = edKey
+		}
+	case "RS256":
+		if rsaKey, err := rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			return key, err
+		} else {
+			private = rsaKey
+		}
+	default:
+		return key, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+
+	if der, err := x509.MarshalPKCS8PrivateKey(private); err != nil {
+		return key, err
+	} else {
+		key.Key = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+
+	return key, nil
+}
+
+// reads the keyset-file, a missing file results in an empty keyset
+func readKeysetFile() (KeysetFile, error) {
+	var file KeysetFile
+
+	if data, err := os.ReadFile(Config.ClientSession.Keyset); errors.Is(err, fs.ErrNotExist) {
+		return file, nil
+	} else if err != nil {
+		return file, err
+	} else if err := json.Unmarshal(data, &file); err != nil {
+		return file, err
+	}
+
+	return file, nil
+}
+
+// writes the keyset-file atomically, so a running server never reads a partial file
+func writeKeysetFile(file KeysetFile) error {
+	data, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(Config.ClientSession.Keyset), ".keyset-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+
+		return err
+	} else if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), Config.ClientSession.Keyset)
+}
+
+// loads the keyset, without keyset-file the "jwt_signature" is used
+func (keys *keyset) load() error {
+	var modified time.Time
+
+	if info, err := os.Stat(Config.ClientSession.Keyset); err == nil {
+		modified = info.ModTime()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	file, err := readKeysetFile()
+	if err != nil {
+		return err
+	}
+
+	parsed := map[string]signingKey{}
+	var current signingKey
+
+	for ii, key := range file.Keys {
+		if signing, err := key.parse(); err != nil {
+			return fmt.Errorf("invalid key %q: %v", key.Kid, err)
+		} else {
+			parsed[key.Kid] = signing
+
+			if ii == 0 {
+				current = signing
+			}
+		}
+	}
+
+	// until the first rotation, the signature from the config-file is used
+	// afterwards it is only valid as long as the keyset-file contains it
+	if len(file.Keys) == 0 && Config.ClientSession.JwtSignature != "" {
+		current = signingKey{
+			kid:    legacyKid,
+			method: jwt.SigningMethodHS256,
+			sign:   []byte(Config.ClientSession.JwtSignature),
+			verify: []byte(Config.ClientSession.JwtSignature),
+		}
+
+		parsed[legacyKid] = current
+	}
+
+	if len(parsed) == 0 {
+		return fmt.Errorf(`neither "client_session.keyset" nor "client_session.jwt_signature" contain a key`)
+	}
+
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+
+	keys.current = current
+	keys.keys = parsed
+	keys.modified = modified
+
+	return nil
+}
+
+// reloads the keyset after it was rotated
+func (keys *keyset) reloadPeriodically() {
+	for {
+		time.Sleep(keysetReloadInterval)
+
+		keys.mutex.RLock()
+		modified := keys.modified
+		keys.mutex.RUnlock()
+
+		if info, err := os.Stat(Config.ClientSession.Keyset); err == nil && !info.ModTime().Equal(modified) {
+			if err := keys.load(); err != nil {
+				logger.Sugar().Errorf("can't reload keyset: %v", err)
+			} else {
+				logger.Info("reloaded keyset")
+			}
+		}
+	}
+}
+
+// signs the claims with the current key
+func (keys *keyset) sign(claims jwt.Claims) (string, error) {
+	keys.mutex.RLock()
+	current := keys.current
+	keys.mutex.RUnlock()
+
+	t := jwt.NewWithClaims(current.method, claims)
+	t.Header["kid"] = current.kid
+
+	return t.SignedString(current.sign)
+}
+
+// returns the verification-key for a token, tokens without "kid" were signed with the "jwt_signature"
+func (keys *keyset) verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+		kid = legacyKid
+	}
+
+	keys.mutex.RLock()
+	key, ok := keys.keys[kid]
+	keys.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown JWT key %q", kid)
+	} else if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected JWT signing method: %v", token.Header["alg"])
+	}
+
+	return key.verify, nil
+}
+
+// command-line rotation: "rotate-keys [HS256|EdDSA|RS256]"
+// the new key signs all new tokens, the previous ones are kept until their tokens expired
+func runRotateKeys(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: rotate-keys [HS256|EdDSA|RS256]")
+	}
+
+	algorithm := Config.ClientSession.Algorithm
+
+	if len(args) == 1 {
+		algorithm = args[0]
+	}
+
+	file, err := readKeysetFile()
+	if err != nil {
+		return err
+	}
+
+	key, err := generateKey(algorithm)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	// keep the signature from the config-file until the tokens signed with it expired
+	if len(file.Keys) == 0 && Config.ClientSession.JwtSignature != "" {
+		file.Keys = append(file.Keys, KeysetKey{
+			Kid:       legacyKid,
+			Algorithm: "HS256",
+			Key:       base64.StdEncoding.EncodeToString([]byte(Config.ClientSession.JwtSignature)),
+		})
+	}
+
+	keep := []KeysetKey{key}
+
+	for _, previous := range file.Keys {
+		if previous.Retired == nil {
+			previous.Retired = &now
+		}
+
+		if previous.Retired.Add(Config.SessionExpire).After(now) {
+			keep = append(keep, previous)
+		} else {
+			fmt.Printf("removed expired key %q\n", previous.Kid)
+		}
+	}
+
+	file.Keys = keep
+
+	if err := writeKeysetFile(file); err != nil {
+		return err
+	}
+
+	fmt.Printf("created %s-key %q, %d previous keys are kept for verification\n", key.Algorithm, key.Kid, len(keep)-1)
+
+	return nil
+}
+
+func init() {
+	if err := keys.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading keyset: %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+type Layout struct {
+	Uid    int
+	Layout string
+}
+
+// creates a deterministic permutation of the door-numbers for the given seed
+func createLayout(seed, days int) []int {
+	random := rand.New(rand.NewPCG(uint64(seed), uint64(days)))
+
+	layout := random.Perm(days)
+
+	// door-numbers start with 1
+	for ii := range layout {
+		layout[ii]++
+	}
+
+	return layout
+}
+
+func formatLayout(layout []int) string {
+	doors := make([]string, len(layout))
+
+	for ii, door := range layout {
+		doors[ii] = strconv.Itoa(door)
+	}
+
+	return strings.Join(doors, ",")
+}
+
+func parseLayout(s string) ([]int, error) {
+	doors := strings.Split(s, ",")
+	layout := make([]int, len(doors))
+
+	for ii, door := range doors {
+		if d, err := strconv.Atoi(door); err != nil {
+			return nil, fmt.Errorf("invalid door %q in layout: %v", door, err)
+		} else {
+			layout[ii] = d
+		}
+	}
+
+	return layout, nil
+}
+
+// retrieves the door-layout of a user from the database and creates it, if there is none yet
+func getLayout(uid int) ([]int, error) {
+	// a calendar-wide layout is stored for uid 0
+	if Config.Setup.Layout == "calendar" {
+		uid = 0
+	}
+
+	days := int(Config.Setup.Days)
+
+	if layouts, err := dbSelect[Layout]("layouts", "uid = ? LIMIT 1", uid); err != nil {
+		return nil, err
+	} else if len(layouts) == 1 {
+		// only use the stored layout if it still matches the calendar
+		if layout, err := parseLayout(layouts[0].Layout); err != nil {
+			logger.Sugar().Warnf("can't parse door-layout of uid = %d: %v", uid, err)
+		} else if len(layout) == days {
+			return layout, nil
+		}
+	}
+
+	layout := createLayout(uid, days)
+
+	if _, err := db.Exec("INSERT INTO layouts (uid, layout) VALUES (?, ?) ON DUPLICATE KEY UPDATE layout = VALUES(layout)", uid, formatLayout(layout)); err != nil {
+		return nil, err
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minimum time between two login-links for the same user, so the endpoint can't be used for spamming
+const magicLinkInterval = time.Minute
+
+type MagicLinkPayload struct {
+	Mid   int    `json:"mid"`
+	Uid   int    `json:"uid"`
+	Stage string `json:"stage"`
+}
+
+type MagicLinkJWT struct {
+	Payload
+	CustomClaims MagicLinkPayload
+}
+
+// sends an email with the configured smtp-server
+func sendMail(to, subject, body string) error {
+	// line-breaks would allow injecting headers
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid mail-header")
+	}
+
+	address := net.JoinHostPort(Config.Smtp.Host, strconv.Itoa(Config.Smtp.Port))
+
+	var auth smtp.Auth
+
+	// without user, no authentication is used, e.g. for a local smtp-sink
+	if Config.Smtp.User != "" {
+		auth = smtp.PlainAuth("", Config.Smtp.User, Config.Smtp.Password, Config.Smtp.Host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + Config.Smtp.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(address, auth, Config.Smtp.From, []string{to}, []byte(message))
+}
+
+// creates a single-use login-link for the user and sends it to them
+func sendMagicLink(user User) error {
+	expires := time.Now().Add(Config.MagicLinkExpire)
+
+	res, err := db.Exec("INSERT INTO magic_links (uid, expires) VALUES (?, ?)", user.Uid, expires)
+	if err != nil {
+		return err
+	}
+
+	mid, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	token, err := Config.signJWTExpiring(MagicLinkPayload{
+		Mid:   int(mid),
+		Uid:   user.Uid,
+		Stage: "magic",
+	}, Config.MagicLinkExpire)
+	if err != nil {
+		return err
+	}
+
+	// the token is in the fragment, so link-scanners of mail-providers can't use it
+	link := strings.TrimSuffix(Config.Server.Url, "/") + "/#" + url.Values{"magic": {token}}.Encode()
+
+	body := fmt.Sprintf("Hallo %s,\r\n\r\nmit diesem Link kannst du dich beim Adventskalender anmelden:\r\n\r\n%s\r\n\r\nDer Link ist bis %s gültig und kann nur einmal benutzt werden.\r\nFalls du keinen Login-Link angefordert hast, kannst du diese E-Mail ignorieren.\r\n",
+		user.publicName(), link, expires.Format("02.01.2006 15:04"))
+
+	return sendMail(*user.Email, "Login-Link für den Adventskalender", body)
+}
+
+// public endpoint to request a login-link, it always succeeds so it doesn't reveal the addresses
+func handleLoginEmail(c *fiber.Ctx) error {
+	var response responseMessage
+
+	body := new(struct {
+		Email string `json:"email"`
+	})
+
+	if !Config.MagicLink.Enabled {
+		response.Status = fiber.StatusNotFound
+	} else if err := c.BodyParser(body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ email string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if email := strings.ToLower(strings.TrimSpace(body.Email)); email == "" {
+		response.Status = fiber.StatusBadRequest
+	} else if users, err := dbSelect[User]("users", "email = ? AND deleted IS NULL LIMIT 1", email); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(users) != 1 {
+		logger.Sugar().Infof("login-link for unknown email requested from %s", c.IP())
+		response.Status = fiber.StatusOK
+	} else if recent, err := dbSelect[struct{ Mid int }]("magic_links", "uid = ? AND created > ? LIMIT 1", users[0].Uid, time.Now().Add(-magicLinkInterval)); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(recent) != 0 {
+		logger.Sugar().Infof("login-link for user %d throttled", users[0].Uid)
+		response.Status = fiber.StatusOK
+	} else {
+		user := users[0]
+
+		// the mail is sent in the background, so the response-time doesn't reveal known addresses
+		go func() {
+			if err := sendMagicLink(user); err != nil {
+				logger.Sugar().Errorf("can't send login-link to user %d: %v", user.Uid, err)
+			} else {
+				logger.Sugar().Infof("sent login-link to user %d", user.Uid)
+			}
+		}()
+
+		response.Status = fiber.StatusOK
+	}
+
+	return response.send(c)
+}
+
+// public endpoint, where the token of a login-link is exchanged for a session
+func handleLoginMagic(c *fiber.Ctx) error {
+	var response responseMessage
+
+	body := new(struct {
+		Token string `json:"token"`
+	})
+
+	var claims MagicLinkJWT
+
+	if !Config.MagicLink.Enabled {
+		response.Status = fiber.StatusNotFound
+	} else if err := c.BodyParser(body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ token string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if token, err := jwt.ParseWithClaims(body.Token, &claims, keys.verificationKey); err != nil || !token.Valid || claims.CustomClaims.Stage != "magic" {
+		logger.Sugar().Infof("invalid login-link used from %s", c.IP())
+		response.Status = fiber.StatusUnauthorized
+		response.Message = "invalid or expired login-link"
+
+		// the link can only be used once, even with concurrent requests
+	} else if res, err := db.Exec("UPDATE magic_links SET used = ? WHERE mid = ? AND uid = ? AND used IS NULL AND expires > ?", time.Now(), claims.CustomClaims.Mid, claims.CustomClaims.Uid, time.Now()); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if count, err := res.RowsAffected(); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if count != 1 {
+		logger.Sugar().Infof("used login-link %d again from %s", claims.CustomClaims.Mid, c.IP())
+		response.Status = fiber.StatusUnauthorized
+		response.Message = "invalid or expired login-link"
+	} else if users, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL LIMIT 1", claims.CustomClaims.Uid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(users) != 1 {
+		response.Status = fiber.StatusUnauthorized
+	} else {
+		logger.Sugar().Infof("user %d logged in with login-link %d", users[0].Uid, claims.CustomClaims.Mid)
+
+		// users with two-factor-authentication have to enter a code first
+		if users[0].TotpEnabled {
+			response = startTwoFactorLogin(c, users[0])
+		} else {
+			response = completeLogin(c, users[0])
+		}
+	}
+
+	return response.send(c)
+}
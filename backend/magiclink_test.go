@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// posts the token of a login-link to the app
+func postMagicToken(t *testing.T, token string) *http.Response {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/login/magic", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func signMagicToken(t *testing.T, payload MagicLinkPayload, expire time.Duration) string {
+	t.Helper()
+
+	token, err := Config.signJWTExpiring(payload, expire)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestMagicLinkSingleUse(t *testing.T) {
+	mock := mockDatabase(t)
+
+	token := signMagicToken(t, MagicLinkPayload{Mid: 5, Uid: 3, Stage: "magic"}, time.Minute)
+
+	mock.ExpectExec(`UPDATE magic_links SET used = \? WHERE mid = \? AND uid = \? AND used IS NULL AND expires > \?`).
+		WithArgs(sqlmock.AnyArg(), 5, 3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users WHERE uid = \?`).
+		WithArgs(3).
+		WillReturnRows(mockRows(User{Uid: 3, Name: "user", Role: roleMember, Tid: 1}))
+	mock.ExpectExec(`INSERT INTO sessions`).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+
+	if resp := postMagicToken(t, token); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first use: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	} else if session := responseCookie(resp, "session"); session == nil || session.Value == "" {
+		t.Fatal("first use didn't set the session-cookie")
+	}
+
+	// the link was marked as used by the first request, so the update doesn't match anymore
+	mock.ExpectExec(`UPDATE magic_links SET used = \?`).
+		WithArgs(sqlmock.AnyArg(), 5, 3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if resp := postMagicToken(t, token); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("second use: got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	} else if responseCookie(resp, "session") != nil {
+		t.Error("second use set a session-cookie")
+	}
+}
+
+func TestMagicLinkRequiresSecondFactor(t *testing.T) {
+	mock := mockDatabase(t)
+
+	token := signMagicToken(t, MagicLinkPayload{Mid: 5, Uid: 3, Stage: "magic"}, time.Minute)
+
+	mock.ExpectExec(`UPDATE magic_links SET used = \?`).
+		WithArgs(sqlmock.AnyArg(), 5, 3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users WHERE uid = \?`).
+		WithArgs(3).
+		WillReturnRows(mockRows(User{Uid: 3, Name: "user", Role: roleMember, Tid: 1, TotpEnabled: true}))
+
+	if resp := postMagicToken(t, token); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	} else if responseCookie(resp, "session") != nil {
+		t.Error("set a session-cookie before the second factor")
+	} else if responseCookie(resp, pendingLoginCookie) == nil {
+		t.Error("didn't start the second login-step")
+	}
+}
+
+func TestMagicLinkInvalidToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", signMagicToken(t, MagicLinkPayload{Mid: 5, Uid: 3, Stage: "magic"}, -time.Minute)},
+		// other tokens of the server can't be used as login-links
+		{"wrong stage", signMagicToken(t, MagicLinkPayload{Mid: 5, Uid: 3, Stage: "2fa"}, time.Minute)},
+		{"no stage", signMagicToken(t, MagicLinkPayload{Mid: 5, Uid: 3}, time.Minute)},
+		{"tampered", signMagicToken(t, MagicLinkPayload{Mid: 5, Uid: 3, Stage: "magic"}, time.Minute) + "x"},
+		{"empty", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the token is rejected before the database is used
+			mockDatabase(t)
+
+			if resp := postMagicToken(t, test.token); resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+			} else if responseCookie(resp, "session") != nil {
+				t.Error("set a session-cookie")
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"net/mail"
 	"os"
 	"reflect"
 	"strconv"
@@ -15,17 +16,25 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var logger zap.Logger
 var db *sql.DB
-var app = fiber.New(fiber.Config{
-	AppName:               "advent-server",
-	DisableStartupMessage: true,
-})
+var app *fiber.App
+
+// creates the server, it is called after loading the config, since it depends on the proxy-settings
+func newApp() *fiber.App {
+	return fiber.New(fiber.Config{
+		AppName:               "advent-server",
+		DisableStartupMessage: true,
+		// behind a reverse-proxy, the client-address is taken from its header, if the request comes from a trusted proxy
+		ProxyHeader:             Config.Server.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          Config.Server.TrustedProxies,
+		EnableIPValidation:      true,
+	})
+}
 
 type responseMessage struct {
 	Status  int
@@ -99,6 +108,8 @@ func init() {
 		Passwd:               Config.Database.Password,
 		Addr:                 Config.Database.Host,
 		DBName:               Config.Database.Database,
+		ParseTime:            true,
+		Loc:                  time.Local,
 	}
 
 	db, _ = sql.Open("mysql", sqlConfig.FormatDSN())
@@ -108,26 +119,43 @@ func init() {
 }
 
 type WelcomeMessage struct {
-	Admin    bool `json:"admin"`
-	LoggedIn bool `json:"logged_in"`
-	Uid      int  `json:"uid"`
+	Admin       bool         `json:"admin"`
+	Role        Role         `json:"role,omitempty"`
+	Permissions []Permission `json:"permissions,omitempty"`
+	// the role only takes effect after enabling two-factor-authentication
+	TwoFactorRequired bool `json:"two_factor_required"`
+	LoggedIn          bool `json:"logged_in"`
+	Uid               int  `json:"uid"`
+	// login with an identity-provider is available
+	Oidc bool `json:"oidc"`
+	// login with a link sent by email is available
+	MagicLink bool `json:"magic_link"`
+	// replies can be nested up to this depth
+	MaxDepth int `json:"max_depth"`
+}
+
+// returns the database-column of a struct-field: either the "db"-tag or the lowercase field-name
+func dbColumn(field reflect.StructField) string {
+	if column := field.Tag.Get("db"); column != "" {
+		return column
+	} else {
+		return strings.ToLower(field.Name)
+	}
 }
 
 func dbSelect[T any](table string, where string, args ...any) ([]T, error) {
-	// validate columns against struct T
+	// get the columns from struct T
 	tType := reflect.TypeOf(new(T)).Elem()
-	columns := make([]string, tType.NumField())
+	columns := []string{}
+	fieldIndices := []int{}
 
-	validColumns := make(map[string]any)
 	for ii := 0; ii < tType.NumField(); ii++ {
 		field := tType.Field(ii)
-		validColumns[strings.ToLower(field.Name)] = struct{}{}
-		columns[ii] = strings.ToLower(field.Name)
-	}
 
-	for _, col := range columns {
-		if _, ok := validColumns[strings.ToLower(col)]; !ok {
-			return nil, fmt.Errorf("invalid column: %s for struct type %T", col, new(T))
+		// skip fields that aren't stored in the database
+		if column := dbColumn(field); column != "-" {
+			columns = append(columns, column)
+			fieldIndices = append(fieldIndices, ii)
 		}
 	}
 
@@ -161,23 +189,19 @@ func dbSelect[T any](table string, where string, args ...any) ([]T, error) {
 	defer rows.Close()
 	results := []T{}
 
-	title := cases.Title(language.Und)
-
 	for rows.Next() {
 		var lineResult T
 
 		scanArgs := make([]any, len(columns))
 		v := reflect.ValueOf(&lineResult).Elem()
 
-		for ii, col := range columns {
-			colTitle := title.String(col)
-
-			field := v.FieldByName(colTitle)
+		for ii, fieldIndex := range fieldIndices {
+			field := v.Field(fieldIndex)
 
-			if field.IsValid() && field.CanSet() {
+			if field.CanSet() {
 				scanArgs[ii] = field.Addr().Interface()
 			} else {
-				logger.Sugar().Warnf("Field %s not found in struct %T", col, lineResult)
+				logger.Sugar().Warnf("Field %s not settable in struct %T", columns[ii], lineResult)
 				scanArgs[ii] = new(any) // save dummy value
 			}
 		}
@@ -205,8 +229,8 @@ func dbCount(table string, where any) (int, error) {
 	v := reflect.ValueOf(where)
 	t := v.Type()
 
-	columns := make([]string, t.NumField())
-	values := make([]any, t.NumField())
+	columns := []string{}
+	values := []any{}
 
 	for ii := 0; ii < t.NumField(); ii++ {
 		fieldValue := v.Field(ii)
@@ -215,8 +239,8 @@ func dbCount(table string, where any) (int, error) {
 		if !fieldValue.IsZero() {
 			field := t.Field(ii)
 
-			columns[ii] = strings.ToLower(field.Name) + " = ?"
-			values[ii] = fmt.Sprint(fieldValue.Interface())
+			columns = append(columns, dbColumn(field)+" = ?")
+			values = append(values, fmt.Sprint(fieldValue.Interface()))
 		}
 	}
 
@@ -226,7 +250,7 @@ func dbCount(table string, where any) (int, error) {
 	completeQuery := fmt.Sprintf("SELECT 1 FROM %s", table)
 
 	if len(values) > 0 {
-		completeQuery = fmt.Sprintf("%s WHERE %s", completeQuery, strings.Join(columns, ", "))
+		completeQuery = fmt.Sprintf("%s WHERE %s", completeQuery, strings.Join(columns, " AND "))
 
 		db.Ping()
 
@@ -276,8 +300,8 @@ func dbInsert(table string, vals any) error {
 	v := reflect.ValueOf(vals)
 	t := v.Type()
 
-	columns := make([]string, t.NumField())
-	values := make([]any, t.NumField())
+	columns := []string{}
+	values := []any{}
 
 	for ii := 0; ii < t.NumField(); ii++ {
 		fieldValue := v.Field(ii)
@@ -286,8 +310,8 @@ func dbInsert(table string, vals any) error {
 		if !fieldValue.IsZero() {
 			field := t.Field(ii)
 
-			columns[ii] = strings.ToLower(field.Name)
-			values[ii] = fieldValue.Interface()
+			columns = append(columns, dbColumn(field))
+			values = append(values, fieldValue.Interface())
 		}
 	}
 
@@ -313,15 +337,15 @@ func dbUpdate(table string, set, where any) error {
 
 		field := setT.Field(ii)
 
-		setColumns[ii] = strings.ToLower(field.Name) + " = ?"
+		setColumns[ii] = dbColumn(field) + " = ?"
 		setValues[ii] = fieldValue.Interface()
 	}
 
 	whereV := reflect.ValueOf(where)
 	whereT := whereV.Type()
 
-	whereColumns := make([]string, whereT.NumField())
-	whereValues := make([]any, whereT.NumField())
+	whereColumns := []string{}
+	whereValues := []any{}
 
 	for ii := 0; ii < whereT.NumField(); ii++ {
 		fieldValue := whereV.Field(ii)
@@ -330,8 +354,8 @@ func dbUpdate(table string, set, where any) error {
 		if !fieldValue.IsZero() {
 			field := whereT.Field(ii)
 
-			whereColumns[ii] = strings.ToLower(field.Name) + " = ?"
-			whereValues[ii] = fmt.Sprint(fieldValue.Interface())
+			whereColumns = append(whereColumns, dbColumn(field)+" = ?")
+			whereValues = append(whereValues, fmt.Sprint(fieldValue.Interface()))
 		}
 	}
 
@@ -352,8 +376,8 @@ func dbDelete(table string, vals any) error {
 	v := reflect.ValueOf(vals)
 	t := v.Type()
 
-	columns := make([]string, t.NumField())
-	values := make([]any, t.NumField())
+	columns := []string{}
+	values := []any{}
 
 	for ii := 0; ii < t.NumField(); ii++ {
 		fieldValue := v.Field(ii)
@@ -362,92 +386,61 @@ func dbDelete(table string, vals any) error {
 		if !fieldValue.IsZero() {
 			field := t.Field(ii)
 
-			columns[ii] = strings.ToLower(field.Name) + " = ?"
-			values[ii] = fmt.Sprint(fieldValue.Interface())
+			columns = append(columns, dbColumn(field)+" = ?")
+			values = append(values, fmt.Sprint(fieldValue.Interface()))
 		}
 	}
 
-	completeQuery := fmt.Sprintf("DELETE FROM %s WHERE %s", table, strings.Join(columns, ", "))
+	completeQuery := fmt.Sprintf("DELETE FROM %s WHERE %s", table, strings.Join(columns, " AND "))
 
 	_, err := db.Exec(completeQuery, values...)
 
 	return err
 }
 
-func extractJWT(c *fiber.Ctx) (int, int, error) {
+// parses and verifies a jwt into the claims
+func parseJWT(tokenString string, claims *JWT) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, keys.verificationKey)
+}
+
+// retrieves the claims of the session-cookie
+func extractClaims(c *fiber.Ctx) (JWTPayload, error) {
 	cookie := c.Cookies("session")
 
-	token, err := jwt.ParseWithClaims(cookie, &JWT{}, func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected JWT signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(Config.ClientSession.JwtSignature), nil
-	})
+	token, err := parseJWT(cookie, &JWT{})
 
 	if err != nil {
-		return 0, 0, err
+		return JWTPayload{}, err
 	}
 
-	if claims, ok := token.Claims.(*JWT); ok && token.Valid {
-		return claims.CustomClaims.Uid, claims.CustomClaims.Tid, nil
+	if claims, ok := token.Claims.(*JWT); ok && token.Valid && claims.CustomClaims.Stage == "" {
+		return claims.CustomClaims, nil
 	} else {
-		return 0, 0, fmt.Errorf("invalid JWT")
+		return JWTPayload{}, fmt.Errorf("invalid JWT")
 	}
 }
 
-func checkUser(c *fiber.Ctx) (bool, error) {
-	uid, tid, err := extractJWT(c)
-
-	if err != nil {
-		return false, err
-	}
-
-	response, err := dbSelect[struct {
-		Tid int
-	}]("users", "uid = ? LIMIT 1", uid)
-
-	if err != nil {
-		return false, err
-	}
-
-	// if exactly one user came back and the tID is valid, the user is authorized
-	return len(response) == 1 && response[0].Tid == tid, err
-}
-
-func checkAdmin(c *fiber.Ctx) (bool, error) {
-	uid, tid, err := extractJWT(c)
-
-	if err != nil {
-		return false, err
-	}
-
-	// retrieve the user from the database
-	response, err := dbSelect[struct {
-		Admin bool
-		Tid   int
-	}]("users", "uid = ? LIMIT 1", uid)
-
-	if err != nil {
-		return false, err
-	}
-
-	// if exactly one user came back and its name is "admin", the user is the admin
-	if len(response) != 1 {
-		return false, fmt.Errorf("user doesn't exist")
+func extractJWT(c *fiber.Ctx) (int, int, error) {
+	if claims, err := extractClaims(c); err != nil {
+		return 0, 0, err
 	} else {
-		return response[0].Admin && response[0].Tid == tid, err
+		return claims.Uid, claims.Tid, nil
 	}
 }
 
 func handleWelcome(c *fiber.Ctx) error {
 	response := responseMessage{}
 	response.Data = WelcomeMessage{
-		LoggedIn: false,
+		LoggedIn:  false,
+		Oidc:      Config.Oidc.Enabled,
+		MagicLink: Config.MagicLink.Enabled,
+		MaxDepth:  Config.Comments.MaxDepth,
 	}
 
-	if uid, tid, err := extractJWT(c); err == nil {
-		if users, err := dbSelect[User]("users", "uid = ? LIMIT 1", strconv.Itoa(uid)); err != nil {
+	refreshExpiredSession(c)
+
+	if claims, err := extractClaims(c); err == nil {
+		if users, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL LIMIT 1", strconv.Itoa(claims.Uid)); err != nil {
 			response.Status = fiber.StatusInternalServerError
 		} else {
 			if len(users) != 1 {
@@ -455,19 +448,33 @@ func handleWelcome(c *fiber.Ctx) error {
 				response.Message = "unknown user"
 
 				removeSessionCookie(c)
-			} else if tid != users[0].Tid {
+			} else if claims.Tid != users[0].Tid {
 				// the token-id is expired
 				response.Status = fiber.StatusUnauthorized
 
 				// remove the cookie
+				removeSessionCookie(c)
+			} else if active, err := activeSession(c, claims.Sid, claims.Uid); err != nil {
+				response.Status = fiber.StatusInternalServerError
+			} else if !active {
+				// the session was revoked
+				response.Status = fiber.StatusUnauthorized
+
 				removeSessionCookie(c)
 			} else {
 				user := users[0]
 
 				response.Data = WelcomeMessage{
-					Uid:      user.Uid,
-					Admin:    user.Admin,
-					LoggedIn: true,
+					Uid:         user.Uid,
+					Admin:       user.Role.admin(),
+					Role:        user.Role,
+					Permissions: user.Role.permissions(),
+					LoggedIn:    true,
+					Oidc:        Config.Oidc.Enabled,
+					MagicLink:   Config.MagicLink.Enabled,
+					MaxDepth:    Config.Comments.MaxDepth,
+
+					TwoFactorRequired: Config.TwoFactor.RequireForAdmins && user.Role.admin() && !user.TotpEnabled,
 				}
 			}
 		}
@@ -482,11 +489,58 @@ type LoginBody struct {
 }
 
 type User struct {
-	Uid      int    `json:"uid"`
-	Name     string `json:"name"`
-	Admin    bool   `json:"admin"`
-	Password []byte `json:"password"`
-	Tid      int    `json:"tid"`
+	Uid      int            `json:"uid"`
+	Name     string         `json:"name"`
+	Role     Role           `json:"role"`
+	Password Secret[[]byte] `json:"-"`
+	Tid      int            `json:"-"`
+	Display  *string        `json:"display_name,omitempty"`
+	Avatar   *string        `json:"avatar,omitempty"`
+	Deleted  *time.Time     `json:"deleted,omitempty"`
+	Email    *string        `json:"-"`
+	// subject of the linked identity-provider-account
+	OidcSubject *string `db:"oidc_subject" json:"-"`
+	// base32-encoded totp-secret and the last used time-step
+	TotpSecret  Secret[string] `db:"totp_secret" json:"-"`
+	TotpEnabled bool           `db:"totp_enabled" json:"-"`
+	TotpCounter int64          `db:"totp_counter" json:"-"`
+}
+
+// user-information that is sent to the clients
+type UserInfo struct {
+	Uid         int        `json:"uid"`
+	Name        string     `json:"name"`
+	Admin       bool       `json:"admin"`
+	Role        Role       `json:"role"`
+	TwoFactor   bool       `json:"two_factor"`
+	DisplayName *string    `json:"display_name,omitempty"`
+	Avatar      *string    `json:"avatar,omitempty"`
+	Email       *string    `json:"email,omitempty"`
+	Deleted     *time.Time `json:"deleted,omitempty"`
+}
+
+func (user User) info() UserInfo {
+	return UserInfo{
+		Uid:         user.Uid,
+		Name:        user.Name,
+		Admin:       user.Role.admin(),
+		Role:        user.Role,
+		TwoFactor:   user.TotpEnabled,
+		DisplayName: user.Display,
+		Avatar:      user.Avatar,
+		Email:       user.Email,
+		Deleted:     user.Deleted,
+	}
+}
+
+func userInfos(users []User) []UserInfo {
+	infos := make([]UserInfo, len(users))
+
+	for ii, user := range users {
+		infos[ii] = user.info()
+	}
+
+	return infos
 }
 
 type LoginInfo struct {
@@ -494,11 +548,16 @@ type LoginInfo struct {
 	Name     string `json:"name"`
 	Admin    bool   `json:"admin"`
 	LoggedIn bool   `json:"logged_in"`
+	// the login has to be completed with a second factor
+	TwoFactor bool `json:"two_factor"`
 }
 
 type JWTPayload struct {
 	Uid int `json:"uid"`
 	Tid int `json:"tid"`
+	Sid int `json:"sid"`
+	// unfinished logins have a stage, that has to be completed first
+	Stage string `json:"stage"`
 }
 
 type JWT struct {
@@ -508,7 +567,7 @@ type JWT struct {
 
 // retrieves the current tid for a specific user from the database
 func getTokenId(uid int) (int, error) {
-	if response, err := dbSelect[User]("users", "uid = ? LIMIT 1", uid); err != nil {
+	if response, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL LIMIT 1", uid); err != nil {
 		return -1, err
 	} else if len(response) != 1 {
 		return -1, fmt.Errorf("can't get user with uid = %q from database", uid)
@@ -517,11 +576,13 @@ func getTokenId(uid int) (int, error) {
 	}
 }
 
-// increases the tid of a user
+// increases the tid of a user and revokes their sessions, so they are logged out everywhere
 func incTokenId(uid int) error {
-	_, err := db.Exec("UPDATE users SET tid = tid + 1 WHERE uid = ?", uid)
+	if _, err := db.Exec("UPDATE users SET tid = tid + 1 WHERE uid = ?", uid); err != nil {
+		return err
+	}
 
-	return err
+	return revokeUserSessions(uid, 0)
 }
 
 func handleLogin(c *fiber.Ctx) error {
@@ -533,14 +594,22 @@ func handleLogin(c *fiber.Ctx) error {
 		logger.Sugar().Warn("error while parsing login-body")
 
 		response.Status = fiber.StatusBadRequest
+	} else if !checkLoginAllowed(c, body.User) {
+		response.Status = fiber.StatusTooManyRequests
+		response.Message = "Too many failed logins"
 	} else {
 		// try to get the hashed password from the database
-		dbResult, err := dbSelect[User]("users", "name = ? LIMIT 1", body.User)
+		dbResult, err := dbSelect[User]("users", "name = ? AND deleted IS NULL LIMIT 1", body.User)
 
 		if err != nil {
 			response.Status = fiber.StatusInternalServerError
 		} else {
 			if len(dbResult) != 1 {
+				// compare against a dummy-hash, so unknown users can't be distinguished by the response-time
+				bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(body.Password))
+
+				limiter.fail(c.IP(), body.User, time.Now())
+
 				response.Status = fiber.StatusUnauthorized
 				response.Message = "Unknown user or wrong password"
 
@@ -554,38 +623,19 @@ func handleLogin(c *fiber.Ctx) error {
 
 				if tid, err := getTokenId(user.Uid); err != nil {
 					response.Status = fiber.StatusInternalServerError
-				} else if len(dbResult) != 1 || tid != user.Tid || bcrypt.CompareHashAndPassword(user.Password, []byte(body.Password)) != nil {
+				} else if len(dbResult) != 1 || tid != user.Tid || bcrypt.CompareHashAndPassword(user.Password.Reveal(), []byte(body.Password)) != nil {
 					response.Status = fiber.StatusUnauthorized
 					response.Message = "Unkown user or wrong password"
 
+					limiter.fail(c.IP(), body.User, time.Now())
+
 					removeSessionCookie(c)
-				} else {
-					// create the jwt
-					jwt, err := Config.signJWT(JWTPayload{
-						Uid: user.Uid,
-						Tid: user.Tid,
-					})
-
-					if err != nil {
-						logger.Sugar().Errorf("failed creating json-webtoken: %v", err.Error())
-						response.Status = fiber.StatusInternalServerError
-					} else {
 
-						c.Cookie(&fiber.Cookie{
-							Name:     "session",
-							Value:    jwt,
-							HTTPOnly: true,
-							SameSite: "strict",
-							MaxAge:   int(Config.SessionExpire.Seconds()),
-						})
-
-						response.Data = LoginInfo{
-							Uid:      user.Uid,
-							Name:     user.Name,
-							Admin:    user.Admin,
-							LoggedIn: true,
-						}
-					}
+					// users with two-factor-authentication have to enter a code first
+				} else if user.TotpEnabled {
+					response = startTwoFactorLogin(c, user)
+				} else {
+					response = completeLogin(c, user)
 				}
 			}
 		}
@@ -594,17 +644,77 @@ func handleLogin(c *fiber.Ctx) error {
 	return response.send(c)
 }
 
-func removeSessionCookie(c *fiber.Ctx) {
+// issues the session-cookie after all login-steps are passed
+func completeLogin(c *fiber.Ctx, user User) responseMessage {
+	var response responseMessage
+
+	if sid, err := createSession(c, user.Uid); err != nil {
+		logger.Sugar().Errorf("can't create session: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else if err := setSessionCookie(c, user.Uid, user.Tid, sid); err != nil {
+		logger.Sugar().Errorf("failed creating json-webtoken: %v", err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		limiter.succeed(user.Name)
+
+		response.Data = LoginInfo{
+			Uid:      user.Uid,
+			Name:     user.Name,
+			Admin:    user.Role.admin(),
+			LoggedIn: true,
+		}
+	}
+
+	return response
+}
+
+// signs a jwt for the user and stores it in the session-cookie
+func setSessionCookie(c *fiber.Ctx, uid, tid, sid int) error {
+	jwt, err := Config.signJWT(JWTPayload{
+		Uid: uid,
+		Tid: tid,
+		Sid: sid,
+	})
+
+	if err != nil {
+		return err
+	}
+
 	c.Cookie(&fiber.Cookie{
 		Name:     "session",
-		Value:    "",
+		Value:    jwt,
 		HTTPOnly: true,
 		SameSite: "strict",
-		Expires:  time.Unix(0, 0),
+		MaxAge:   int(Config.AccessExpire.Seconds()),
 	})
+
+	// the following handlers of the request use the new token
+	c.Request().Header.SetCookie("session", jwt)
+
+	return nil
+}
+
+func removeSessionCookie(c *fiber.Ctx) {
+	for _, name := range []string{"session", "refresh"} {
+		c.Cookie(&fiber.Cookie{
+			Name:     name,
+			Value:    "",
+			HTTPOnly: true,
+			SameSite: "strict",
+			Expires:  time.Unix(0, 0),
+		})
+	}
 }
 
 func handleLogout(c *fiber.Ctx) error {
+	refreshExpiredSession(c)
+
+	if claims, err := extractClaims(c); err == nil {
+		if err := revokeSession(claims.Sid); err != nil {
+			logger.Sugar().Errorf("can't revoke session: %v", err)
+		}
+	}
+
 	removeSessionCookie(c)
 
 	return responseMessage{
@@ -613,18 +723,30 @@ func handleLogout(c *fiber.Ctx) error {
 }
 
 type PostsConfig struct {
-	Start string `json:"start"`
-	Days  int8   `json:"days"`
+	Start  string `json:"start"`
+	Days   int8   `json:"days"`
+	Layout []int  `json:"layout"`
 }
 
-func getPostsConfig(_ *fiber.Ctx) responseMessage {
-	return responseMessage{
-		Status: fiber.StatusOK,
-		Data: PostsConfig{
-			Start: Config.Setup.Start,
-			Days:  Config.Setup.Days,
-		},
+func getPostsConfig(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if layout, err := getLayout(uid); err != nil {
+		logger.Sugar().Errorf("can't get door-layout: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Status = fiber.StatusOK
+		response.Data = PostsConfig{
+			Start:  Config.Setup.Start,
+			Days:   Config.Setup.Days,
+			Layout: layout,
+		}
 	}
+
+	return response
 }
 
 type Post struct {
@@ -647,7 +769,7 @@ func getPosts(c *fiber.Ctx) responseMessage {
 		}
 	} else {
 		// if there is no pid given and the user is an admin, send all posts
-		if admin, err := checkAdmin(c); err != nil {
+		if admin, err := checkPermission(c, permPosts); err != nil {
 			response.Status = fiber.StatusInternalServerError
 		} else if admin {
 			if posts, err := dbSelect[Post]("posts", ""); err != nil {
@@ -667,11 +789,11 @@ func getPosts(c *fiber.Ctx) responseMessage {
 func patchPosts(c *fiber.Ctx) responseMessage {
 	var response responseMessage
 
-	if admin, err := checkAdmin(c); err != nil {
+	if allowed, err := checkPermission(c, permPosts); err != nil {
 		logger.Sugar().Error(err.Error())
 		response.Status = fiber.StatusInternalServerError
-	} else if !admin {
-		logger.Sugar().Warn("user is no admin")
+	} else if !allowed {
+		logger.Sugar().Warn("user lacks the permission")
 		response.Status = fiber.StatusForbidden
 	} else {
 		body := new(struct{ Content string })
@@ -694,49 +816,171 @@ func patchPosts(c *fiber.Ctx) responseMessage {
 	return response
 }
 
+// retrieves the date of a post, returns an empty string if the post doesn't exist
+func getPostDate(pid int) (string, error) {
+	if posts, err := dbSelect[struct{ Date string }]("posts", "pid = ? LIMIT 1", pid); err != nil {
+		return "", err
+	} else if len(posts) != 1 {
+		return "", nil
+	} else {
+		return posts[0].Date, nil
+	}
+}
+
 type Comment struct {
-	Cid    int     `json:"cid"`
-	Pid    int     `json:"pid"`
-	Uid    int     `json:"uid"`
-	Text   string  `json:"text"`
-	Answer *string `json:"answer,omitempty"`
+	Cid    int    `json:"cid"`
+	Pid    int    `json:"pid"`
+	Uid    int    `json:"uid"`
+	Parent int    `json:"parent"`
+	Text   string `json:"text"`
+	// legacy answer of an admin, new answers are stored as replies
+	Answer  *string    `json:"answer,omitempty"`
+	Created time.Time  `json:"created"`
+	Edited  *time.Time `json:"edited,omitempty"`
+	// moderation-state and the reason of a rejection
+	State   string     `json:"state"`
+	Reason  *string    `json:"reason,omitempty"`
+	Deleted *time.Time `json:"deleted,omitempty"`
 }
 type Comments []Comment
 
+type CommentResult struct {
+	Comment
+	Author    Author          `json:"author"`
+	Reactions []ReactionCount `json:"reactions"`
+}
+
+// adds the authors and the aggregated reactions to the comments
+func addCommentDetails(comments []Comment, uid int) ([]CommentResult, error) {
+	cids := make([]int, len(comments))
+	uids := make([]int, len(comments))
+
+	for ii, comment := range comments {
+		cids[ii] = comment.Cid
+		uids[ii] = comment.Uid
+	}
+
+	reactions, err := getReactionCounts("cid", cids, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	authors, err := getAuthors(uids)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]CommentResult, len(comments))
+
+	for ii, comment := range comments {
+		results[ii] = CommentResult{
+			Comment:   comment,
+			Author:    authors[comment.Uid],
+			Reactions: reactions[comment.Cid],
+		}
+
+		// authors of deleted users have no name
+		results[ii].Author.Uid = comment.Uid
+
+		if results[ii].Reactions == nil {
+			results[ii].Reactions = []ReactionCount{}
+		}
+	}
+
+	return results, nil
+}
+
 type CommentInsert struct {
-	Pid  int    `json:"pid"`
-	Uid  int    `json:"uid"`
-	Text string `json:"text"`
+	Pid    int    `json:"pid"`
+	Uid    int    `json:"uid"`
+	Parent int    `json:"parent"`
+	Text   string `json:"text"`
+	State  string `json:"state"`
 }
 
 func getComments(c *fiber.Ctx) responseMessage {
 	var response responseMessage
 
-	if pid := c.QueryInt("pid", -1); pid >= 0 {
-		comments, err := dbSelect[Comment]("comments", "pid = ?", pid)
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if admin, err := checkPermission(c, permComments); err != nil {
+		response.Status = fiber.StatusInternalServerError
+
+		// admins get the filterable listing of all comments
+	} else if admin {
+		response = listComments(c, uid)
+	} else {
+		response = getPostComments(c, c.QueryInt("pid", -1))
+	}
+
+	return response
+}
+
+// sends the comments of a post or, if pid is negative and the user is an admin, all comments
+func getPostComments(c *fiber.Ctx, pid int) responseMessage {
+	var response responseMessage
+
+	var comments []Comment
+
+	uid, _, err := extractJWT(c)
+	if err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+
+		return response
+	}
+
+	admin, err := checkPermission(c, permComments)
+	if err != nil {
+		response.Status = fiber.StatusInternalServerError
+
+		return response
+	}
+
+	if pid >= 0 {
+		// users only see approved comments and their own ones
+		if admin {
+			comments, err = dbSelect[Comment]("comments", "pid = ? AND deleted IS NULL", pid)
+		} else {
+			comments, err = dbSelect[Comment]("comments", "pid = ? AND deleted IS NULL AND (state = ? OR uid = ?)", pid, commentApproved, uid)
+		}
 
 		if err != nil {
 			logger.Sugar().Error(err.Error())
 			response.Status = fiber.StatusInternalServerError
-		} else {
-			response.Data = comments
+		} else if !admin {
+			// only keep the comments the user is entitled to see
+			if postDate, err := getPostDate(pid); err != nil {
+				response.Status = fiber.StatusInternalServerError
+			} else if rules, _, err := getCommentRules(pid); err != nil {
+				logger.Sugar().Errorf("can't retrieve comment-rules: %v", err)
+				response.Status = fiber.StatusInternalServerError
+			} else if comments, err = rules.visibleComments(comments, uid, postDate, time.Now()); err != nil {
+				logger.Sugar().Error(err.Error())
+				response.Status = fiber.StatusInternalServerError
+			}
 		}
 	} else {
 		// if there is no pid given and the user is an admin, send all comments
-		if admin, err := checkAdmin(c); err != nil {
-			response.Status = fiber.StatusInternalServerError
-		} else if admin {
-			if posts, err := dbSelect[Comment]("comments", ""); err != nil {
+		if admin {
+			if comments, err = dbSelect[Comment]("comments", "deleted IS NULL"); err != nil {
 				response.Status = fiber.StatusInternalServerError
-			} else {
-				response.Data = posts
 			}
-
 		} else {
 			response.Status = fiber.StatusUnauthorized
 		}
 	}
 
+	if response.Status == 0 {
+		if results, err := addCommentDetails(comments, uid); err != nil {
+			logger.Sugar().Errorf("can't retrieve comment-details: %v", err)
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			response.Data = results
+		}
+	}
+
 	return response
 }
 
@@ -748,47 +992,50 @@ func postComments(c *fiber.Ctx) responseMessage {
 	} else if uid, _, err := extractJWT(c); err != nil {
 		logger.Sugar().Error(err.Error())
 	} else {
-		// check wether the post-date is today
-		if dbResponse, err := dbSelect[struct{ Date string }]("posts", "pid = ? LIMIT 1", pid); err != nil {
+		body := new(struct {
+			Text string `json:"text"`
+		})
+
+		// check wether the door accepts comments
+		if postDate, err := getPostDate(pid); err != nil {
 			response.Status = fiber.StatusInternalServerError
-		} else if len(dbResponse) != 1 {
+		} else if postDate == "" {
 			response.Status = fiber.StatusBadRequest
-		} else {
-			postDate := dbResponse[0].Date
-
-			today := time.Now().Format(time.DateOnly)
+		} else if rules, _, err := getCommentRules(pid); err != nil {
+			logger.Sugar().Errorf("can't retrieve comment-rules: %v", err)
+			response.Status = fiber.StatusInternalServerError
+		} else if open, err := rules.open(postDate, time.Now()); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else if !open {
+			response.Status = fiber.StatusForbidden
 
-			if postDate != today {
-				response.Status = fiber.StatusForbidden
+			// check wether the user already posted the maximum number of comments (replies don't count)
+		} else if posts, err := dbSelect[struct{ Cid int }]("comments", "pid = ? AND uid = ? AND parent = 0 AND state != ? AND deleted IS NULL", pid, uid, commentRejected); err != nil {
+			response.Status = fiber.StatusInternalServerError
+		} else if rules.MaxPerUser > 0 && len(posts) >= rules.MaxPerUser {
+			response.Status = fiber.StatusConflict
+		} else if err := c.BodyParser(&body); err != nil {
+			logger.Sugar().Warn(`"body" can't be parsed as "{ text string }"`)
+			response.Status = fiber.StatusBadRequest
+		} else if !rules.validLength(body.Text) {
+			logger.Info("comment-length violates the comment-rules")
+			response.Status = fiber.StatusBadRequest
+		} else if text, state, filterResult := filterComment(body.Text, uid); filterResult.Action == filterReject {
+			response.Status = fiber.StatusBadRequest
+			response.Message = "comment was rejected by the filter"
+		} else {
+			// everything is valid, add the comment
+			if err := dbInsert("comments", CommentInsert{
+				Pid:   pid,
+				Uid:   uid,
+				Text:  text,
+				State: state,
+			}); err != nil {
+				logger.Sugar().Warnf("Writing comment to database failed with error: %v", err.Error())
+				response.Status = fiber.StatusInternalServerError
 			} else {
-				// check wether the user already posted
-				if posts, err := dbSelect[struct{ Cid int }]("comments", "pid = ? AND uid = ?", pid, uid); err != nil {
-					response.Status = fiber.StatusInternalServerError
-				} else if len(posts) != 0 {
-					response.Status = fiber.StatusConflict
-				} else {
-					// everything is valid, add the comment
-
-					body := new(struct {
-						Text string `json:"text"`
-					})
-
-					if err := c.BodyParser(&body); err != nil {
-						logger.Sugar().Warn(`"body" can't be parsed as "{ text string }"`)
-						response.Status = fiber.StatusBadRequest
-					} else {
-						if err := dbInsert("comments", CommentInsert{
-							Pid:  pid,
-							Uid:  uid,
-							Text: body.Text,
-						}); err != nil {
-							logger.Sugar().Warnf("Writing comment to database failed with error: %v", err.Error())
-							response.Status = fiber.StatusInternalServerError
-						} else {
-							response = getComments(c)
-						}
-					}
-				}
+				response = getComments(c)
 			}
 		}
 	}
@@ -805,32 +1052,51 @@ func deleteComments(c *fiber.Ctx) responseMessage {
 		response.Status = fiber.StatusBadRequest
 	} else {
 		// check wether the user has the permissions
-		if admin, err := checkAdmin(c); err != nil {
+		if comments, err := dbSelect[Comment]("comments", "cid = ? AND deleted IS NULL LIMIT 1", cid); err != nil {
 			response.Status = fiber.StatusInternalServerError
-		} else if !admin {
+		} else if len(comments) != 1 {
+			logger.Sugar().Infof("comment %d doesn't exist", cid)
+			response.Status = fiber.StatusBadRequest
+		} else if admin, err := checkPermission(c, permComments); err != nil {
+			response.Status = fiber.StatusInternalServerError
+		} else if allowed, err := canModifyComment(c, comments[0], admin); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else if !allowed {
 			response.Status = fiber.StatusForbidden
 		} else {
-			// everything is good
-
-			if err := dbDelete("comments", struct{ Cid int }{cid}); err != nil {
-				logger.Sugar().Warnf("Deleting comment from database failed with error: %v", err.Error())
+			// everything is good, delete the comment with all its replies
+			if thread, err := getCommentThread(cid); err != nil {
+				logger.Sugar().Errorf("can't retrieve replies of comment: %v", err)
 				response.Status = fiber.StatusInternalServerError
+			} else {
+				// the comments are moved to the trash and purged after the retention-period
+				if err := softDelete("comments", "cid", thread); err != nil {
+					logger.Sugar().Warnf("Deleting comment from database failed with error: %v", err.Error())
+					response.Status = fiber.StatusInternalServerError
+				}
 			}
 
-			response = getComments(c)
+			// admins get all comments, authors only the ones of the post
+			if admin {
+				response = getComments(c)
+			} else {
+				response = getPostComments(c, comments[0].Pid)
+			}
 		}
 	}
 
 	return response
 }
 
+// legacy endpoint for admin-answers, the answer is added as a reply
 func postCommentsAnswer(c *fiber.Ctx) responseMessage {
 	var response responseMessage
 
-	// check wether the user is an admin = allowed to post an answer
-	if admin, err := checkAdmin(c); err != nil {
+	// check wether the user is allowed to post an answer
+	if allowed, err := checkPermission(c, permComments); err != nil {
 		response.Status = fiber.StatusInternalServerError
-	} else if !admin {
+	} else if !allowed {
 		response.Status = fiber.StatusForbidden
 	} else {
 		if cid := c.QueryInt("cid", -1); cid < 0 {
@@ -844,16 +1110,7 @@ func postCommentsAnswer(c *fiber.Ctx) responseMessage {
 				logger.Info(err.Error())
 				response.Status = fiber.StatusBadRequest
 			} else {
-				if err := dbUpdate("comments", struct{ Answer string }{Answer: body.Answer}, struct{ Cid int }{Cid: cid}); err != nil {
-					logger.Sugar().Error(err.Error())
-					response.Status = fiber.StatusInternalServerError
-				} else {
-					if comments, err := dbSelect[Comment]("comments", "cid = ?", cid); err != nil || len(comments) != 1 {
-						response.Status = fiber.StatusInternalServerError
-					} else {
-						response.Data = comments[0]
-					}
-				}
+				response = addReply(c, cid, body.Answer)
 			}
 		}
 	}
@@ -864,18 +1121,13 @@ func postCommentsAnswer(c *fiber.Ctx) responseMessage {
 func getUsers(c *fiber.Ctx) responseMessage {
 	var response responseMessage
 
-	// check wether the user is an admin
-	isAdmin, err := checkAdmin(c)
+	// check wether the user is allowed to manage users
+	allowed, err := checkPermission(c, permUsers)
 
 	if err != nil {
 		response.Status = fiber.StatusInternalServerError
-	} else if isAdmin {
-		// retrieve all users
-		if users, err := dbSelect[User]("users", ""); err != nil {
-			response.Status = fiber.StatusInternalServerError
-		} else {
-			response.Data = users
-		}
+	} else if allowed {
+		response = listUsers(c)
 	} else {
 		response.Status = fiber.StatusForbidden
 	}
@@ -890,11 +1142,11 @@ func hashPassword(password string) ([]byte, error) {
 func postUsers(c *fiber.Ctx) responseMessage {
 	var response responseMessage
 
-	if admin, err := checkAdmin(c); err != nil {
+	if allowed, err := checkPermission(c, permUsers); err != nil {
 		logger.Sugar().Error(err.Error())
 		response.Status = fiber.StatusInternalServerError
-	} else if !admin {
-		logger.Sugar().Warn("user is no admin")
+	} else if !allowed {
+		logger.Sugar().Warn("user lacks the permission")
 		response.Status = fiber.StatusForbidden
 	} else {
 		body := new(struct {
@@ -906,6 +1158,9 @@ func postUsers(c *fiber.Ctx) responseMessage {
 		if err := c.BodyParser(&body); err != nil {
 			logger.Info(err.Error())
 			response.Status = fiber.StatusBadRequest
+		} else if err := validPassword(body.Password); err != nil {
+			response.Status = fiber.StatusBadRequest
+			response.Message = err.Error()
 		} else {
 			// check wether a user with the same name already exists
 			if userCount, err := dbCount("users", struct{ Name string }{Name: body.Name}); err != nil {
@@ -922,8 +1177,8 @@ func postUsers(c *fiber.Ctx) responseMessage {
 				} else {
 					if err := dbInsert("users", struct {
 						Name     string
-						Password []byte
-					}{Name: body.Name, Password: hashedPassword}); err != nil {
+						Password Secret[[]byte]
+					}{Name: body.Name, Password: newSecret(hashedPassword)}); err != nil {
 						logger.Sugar().Error(err.Error())
 						response.Status = fiber.StatusInternalServerError
 					} else {
@@ -940,16 +1195,19 @@ func postUsers(c *fiber.Ctx) responseMessage {
 func patchUsers(c *fiber.Ctx) responseMessage {
 	var response responseMessage
 
-	if admin, err := checkAdmin(c); err != nil {
+	if allowed, err := checkPermission(c, permUsers); err != nil {
 		logger.Sugar().Error(err.Error())
 		response.Status = fiber.StatusInternalServerError
-	} else if !admin {
-		logger.Sugar().Warn("user is no admin")
+	} else if !allowed {
+		logger.Sugar().Warn("user lacks the permission")
 		response.Status = fiber.StatusForbidden
 	} else {
 		body := new(struct {
-			Password string
-			Admin    bool
+			Name     string `json:"name"`
+			Password string `json:"password"`
+			Role     Role   `json:"role"`
+			// nil keeps the address, an empty string removes it
+			Email *string `json:"email"`
 		})
 
 		if uid := c.QueryInt("uid", -1); uid < 0 {
@@ -958,7 +1216,7 @@ func patchUsers(c *fiber.Ctx) responseMessage {
 		} else if err := c.BodyParser(&body); err != nil {
 			logger.Info(err.Error())
 			response.Status = fiber.StatusBadRequest
-		} else if modifyUsers, err := dbSelect[User]("users", "uid = ?", uid); err != nil {
+		} else if modifyUsers, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL", uid); err != nil {
 			logger.Sugar().Error(err.Error())
 			response.Status = fiber.StatusInternalServerError
 		} else if len(modifyUsers) != 1 {
@@ -968,7 +1226,7 @@ func patchUsers(c *fiber.Ctx) responseMessage {
 			if requestUid, _, err := extractJWT(c); err != nil {
 				logger.Info(err.Error())
 				response.Status = fiber.StatusBadRequest
-			} else if requestUsers, err := dbSelect[User]("users", "uid = ?", requestUid); err != nil {
+			} else if requestUsers, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL", requestUid); err != nil {
 				logger.Sugar().Error(err.Error())
 				response.Status = fiber.StatusInternalServerError
 			} else if len(requestUsers) != 1 {
@@ -978,17 +1236,38 @@ func patchUsers(c *fiber.Ctx) responseMessage {
 				modifyUser := modifyUsers[0]
 				requestUser := requestUsers[0]
 
+				// if requested, rename the user
+				if body.Name != "" && body.Name != modifyUser.Name {
+					// only allow the owner to rename themselves
+					if modifyUser.Role == roleOwner && requestUser.Uid != modifyUser.Uid {
+						logger.Sugar().Error(`owner can only be renamed by themselves`)
+						response.Status = fiber.StatusForbidden
+					} else if userCount, err := dbCount("users", struct{ Name string }{Name: body.Name}); err != nil {
+						logger.Sugar().Error(err.Error())
+						response.Status = fiber.StatusInternalServerError
+					} else if userCount != 0 {
+						logger.Sugar().Debugf("user with name %q already exists", body.Name)
+						response.Status = fiber.StatusConflict
+					} else if err := dbUpdate("users", struct{ Name string }{Name: body.Name}, struct{ Uid int }{Uid: modifyUser.Uid}); err != nil {
+						logger.Sugar().Error(err.Error())
+						response.Status = fiber.StatusInternalServerError
+					}
+				}
+
 				// if requested, modify the password
-				if len(body.Password) > 0 {
-					// only allow admin to change himself
-					if modifyUser.Name == "admin" && requestUser.Name != "admin" {
-						logger.Sugar().Error(`password of user "admin" can only be changed by himself`)
+				if response.Status == 0 && len(body.Password) > 0 {
+					// only allow the owner to change their own password
+					if modifyUser.Role == roleOwner && requestUser.Uid != modifyUser.Uid {
+						logger.Sugar().Error(`password of the owner can only be changed by themselves`)
 						response.Status = fiber.StatusForbidden
 
 						// check wether the current-user tries to modify himself
-					} else if requestUser.Name == modifyUser.Name && requestUser.Name != "admin" {
+					} else if requestUser.Uid == modifyUser.Uid && requestUser.Role != roleOwner {
 						logger.Sugar().Error(`can't change own password`)
 						response.Status = fiber.StatusForbidden
+					} else if err := validPassword(body.Password); err != nil {
+						response.Status = fiber.StatusBadRequest
+						response.Message = err.Error()
 					} else {
 						if hashedPassword, err := hashPassword(body.Password); err != nil {
 							logger.Sugar().Error(err.Error())
@@ -997,7 +1276,7 @@ func patchUsers(c *fiber.Ctx) responseMessage {
 							// increase the token-id
 							if err := incTokenId(modifyUser.Uid); err != nil {
 								response.Status = fiber.StatusInternalServerError
-							} else if err := dbUpdate("users", struct{ Password []byte }{Password: hashedPassword}, struct{ Uid int }{Uid: modifyUser.Uid}); err != nil {
+							} else if err := dbUpdate("users", struct{ Password Secret[[]byte] }{Password: newSecret(hashedPassword)}, struct{ Uid int }{Uid: modifyUser.Uid}); err != nil {
 								logger.Sugar().Error(err.Error())
 								response.Status = fiber.StatusInternalServerError
 							}
@@ -1005,27 +1284,58 @@ func patchUsers(c *fiber.Ctx) responseMessage {
 					}
 				}
 
-				// only proceed, if there is no response.Status set already
-				if response.Status == 0 {
-					// disallow demoting of the "admin"-user
-					if modifyUser.Name == "admin" {
-						if !modifyUser.Admin {
-							logger.Sugar().Error(`"admin"-user can't be demoted"`)
-							response.Status = fiber.StatusForbidden
-						}
+				// if requested, change the email-address, it is used for linking logins
+				if response.Status == 0 && body.Email != nil {
+					email := strings.ToLower(strings.TrimSpace(*body.Email))
+
+					if modifyUser.Role == roleOwner && requestUser.Uid != modifyUser.Uid {
+						logger.Sugar().Error(`email of the owner can only be changed by themselves`)
+						response.Status = fiber.StatusForbidden
+					} else if address, err := mail.ParseAddress(email); email != "" && (err != nil || address.Address != email) {
+						logger.Sugar().Infof("invalid email %q", email)
+						response.Status = fiber.StatusBadRequest
+					} else if count, err := dbCount("users", struct{ Email string }{Email: email}); err != nil {
+						logger.Sugar().Error(err.Error())
+						response.Status = fiber.StatusInternalServerError
+					} else if email != "" && count != 0 && (modifyUser.Email == nil || *modifyUser.Email != email) {
+						logger.Sugar().Debugf("email %q is already used", email)
+						response.Status = fiber.StatusConflict
+					} else if _, err := db.Exec("UPDATE users SET email = ? WHERE uid = ?", nilIfEmpty(email), modifyUser.Uid); err != nil {
+						logger.Sugar().Error(err.Error())
+						response.Status = fiber.StatusInternalServerError
+					}
+				}
+
+				// if requested and there is no response.Status set already, modify the role
+				if response.Status == 0 && body.Role != "" && body.Role != modifyUser.Role {
+					if !validRole(body.Role) {
+						logger.Sugar().Infof("invalid role %q", body.Role)
+						response.Status = fiber.StatusBadRequest
+
+						// there is only one owner, the ownership has to be transferred
+					} else if body.Role == roleOwner {
+						logger.Sugar().Info(`the owner-role can only be transferred`)
+						response.Status = fiber.StatusBadRequest
+
+						// disallow demoting of the owner
+					} else if modifyUser.Role == roleOwner {
+						logger.Sugar().Error(`owner can't be demoted`)
+						response.Status = fiber.StatusForbidden
 
 						// check wether the current-user tries to modify himself
-					} else if requestUser.Name == modifyUser.Name {
-						logger.Sugar().Error(`can't demote self`)
+					} else if requestUser.Uid == modifyUser.Uid {
+						logger.Sugar().Error(`can't change own role`)
 						response.Status = fiber.StatusForbidden
+					} else if err := dbUpdate("users", struct{ Role Role }{Role: body.Role}, struct{ Uid int }{Uid: modifyUser.Uid}); err != nil {
+						logger.Sugar().Error(err.Error())
+						response.Status = fiber.StatusInternalServerError
 					} else {
-						if err := dbUpdate("users", struct{ Admin bool }{Admin: body.Admin}, struct{ Uid int }{Uid: modifyUser.Uid}); err != nil {
-							logger.Sugar().Error(err.Error())
-							response.Status = fiber.StatusInternalServerError
-						}
+						logger.Sugar().Infof("role of user %d changed to %q", modifyUser.Uid, body.Role)
 					}
+				}
 
-					// send the users
+				// send the users
+				if response.Status == 0 {
 					response = getUsers(c)
 				}
 			}
@@ -1039,17 +1349,17 @@ func deleteUsers(c *fiber.Ctx) responseMessage {
 	var response responseMessage
 
 	// check wether the user is an admin
-	if admin, err := checkAdmin(c); err != nil {
+	if allowed, err := checkPermission(c, permUsers); err != nil {
 		logger.Sugar().Error(err.Error())
 		response.Status = fiber.StatusInternalServerError
-	} else if !admin {
-		logger.Sugar().Warn("user is no admin")
+	} else if !allowed {
+		logger.Sugar().Warn("user lacks the permission")
 		response.Status = fiber.StatusForbidden
 	} else {
 		if uid := c.QueryInt("uid", -1); uid < 0 {
 			logger.Info(`query doesn't include valid "uid"`)
 			response.Status = fiber.StatusBadRequest
-		} else if modifyUsers, err := dbSelect[User]("users", "uid = ?", uid); err != nil {
+		} else if modifyUsers, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL", uid); err != nil {
 			logger.Sugar().Error(err.Error())
 			response.Status = fiber.StatusInternalServerError
 		} else if len(modifyUsers) != 1 {
@@ -1059,7 +1369,7 @@ func deleteUsers(c *fiber.Ctx) responseMessage {
 			if requestUid, _, err := extractJWT(c); err != nil {
 				logger.Info(err.Error())
 				response.Status = fiber.StatusBadRequest
-			} else if requestUsers, err := dbSelect[User]("users", "uid = ?", requestUid); err != nil {
+			} else if requestUsers, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL", requestUid); err != nil {
 				logger.Sugar().Error(err.Error())
 				response.Status = fiber.StatusInternalServerError
 			} else if len(requestUsers) != 1 {
@@ -1069,17 +1379,23 @@ func deleteUsers(c *fiber.Ctx) responseMessage {
 				deleteUser := modifyUsers[0]
 				requestUser := requestUsers[0]
 
-				// disallow deleting of the "admin"-user
-				if deleteUser.Name == "admin" {
-					logger.Sugar().Error(`"admin"-user can't be deleted"`)
+				// disallow deleting of the owner
+				if deleteUser.Role == roleOwner {
+					logger.Sugar().Error(`owner can't be deleted`)
 					response.Status = fiber.StatusForbidden
 
 					// check wether the current-user tries to modify himself
-				} else if requestUser.Name == deleteUser.Name {
+				} else if requestUser.Uid == deleteUser.Uid {
 					logger.Sugar().Error(`can't delete self`)
 					response.Status = fiber.StatusForbidden
 				} else {
-					if err := dbDelete("users", struct{ Uid int }{Uid: deleteUser.Uid}); err != nil {
+					// the user is moved to the trash and purged after the retention-period
+					if err := softDelete("users", "uid", []int{deleteUser.Uid}); err != nil {
+						logger.Sugar().Error(err.Error())
+						response.Status = fiber.StatusInternalServerError
+
+						// log out the user everywhere
+					} else if err := incTokenId(deleteUser.Uid); err != nil {
 						logger.Sugar().Error(err.Error())
 						response.Status = fiber.StatusInternalServerError
 					} else {
@@ -1093,31 +1409,80 @@ func deleteUsers(c *fiber.Ctx) responseMessage {
 	return response
 }
 
+// api-endpoint with the permission required to access it
+type endpoint struct {
+	handler    func(*fiber.Ctx) responseMessage
+	permission Permission
+}
+
 func init() {
 	// handle specific request special
 	app.Get("/api/welcome", handleWelcome)
 	app.Post("/api/login", handleLogin)
+	app.Post("/api/login/2fa", handleLoginTwoFactor)
+	app.Post("/api/register", handleRegister)
+	app.Post("/api/refresh", handleRefresh)
+	app.Post("/api/login/email", handleLoginEmail)
+	app.Post("/api/login/magic", handleLoginMagic)
+	app.Get("/api/oidc/login", handleOidcLogin)
+	app.Get("/api/oidc/callback", handleOidcCallback)
 	app.Get("/api/logout", handleLogout)
 
-	endpoints := map[string]map[string]func(*fiber.Ctx) responseMessage{
+	endpoints := map[string]map[string]endpoint{
 		"GET": {
-			"posts":        getPosts,
-			"posts/config": getPostsConfig,
-			"users":        getUsers,
-			"comments":     getComments,
+			"posts":                  {getPosts, permLogin},
+			"posts/config":           {getPostsConfig, permLogin},
+			"posts/rules":            {getPostsRules, permLogin},
+			"users":                  {getUsers, permUsers},
+			"comments":               {getComments, permLogin},
+			"polls":                  {getPolls, permLogin},
+			"reactions":              {getReactions, permLogin},
+			"comments/history":       {getCommentsHistory, permLogin},
+			"account":                {getAccount, permLogin},
+			"comments/pending":       {getCommentsPending, permComments},
+			"comments/pending/count": {getCommentsPendingCount, permComments},
+			"trash/comments":         {getTrashComments, permComments},
+			"login/lockouts":         {getLoginLockouts, permUsers},
+			"trash/users":            {getTrashUsers, permUsers},
+			"invites":                {getInvites, permUsers},
+			"account/sessions":       {getAccountSessions, permLogin},
 		},
 		"POST": {
-			"comments":        postComments,
-			"comments/answer": postCommentsAnswer,
-			"users":           postUsers,
+			"comments":             {postComments, permLogin},
+			"comments/answer":      {postCommentsAnswer, permComments},
+			"comments/reply":       {postCommentsReply, permLogin},
+			"comments/approve":     {postCommentsApprove, permComments},
+			"comments/reject":      {postCommentsReject, permComments},
+			"comments/restore":     {postCommentsRestore, permComments},
+			"users/restore":        {postUsersRestore, permUsers},
+			"users/owner":          {postUsersOwner, permUsers},
+			"users/import":         {postUsersImport, permUsers},
+			"invites":              {postInvites, permUsers},
+			"users":                {postUsers, permUsers},
+			"polls":                {postPolls, permPosts},
+			"polls/vote":           {postPollsVote, permLogin},
+			"reactions":            {postReactions, permLogin},
+			"account/password":     {postAccountPassword, permLogin},
+			"account/2fa":          {postAccount2fa, permLogin},
+			"account/2fa/enable":   {postAccount2faEnable, permLogin},
+			"account/2fa/disable":  {postAccount2faDisable, permLogin},
+			"account/2fa/recovery": {postAccount2faRecovery, permLogin},
 		},
 		"PATCH": {
-			"posts": patchPosts,
-			"users": patchUsers,
+			"posts":       {patchPosts, permPosts},
+			"posts/rules": {patchPostsRules, permPosts},
+			"account":     {patchAccount, permLogin},
+			"comments":    {patchComments, permLogin},
+			"users":       {patchUsers, permUsers},
 		},
 		"DELETE": {
-			"comments": deleteComments,
-			"users":    deleteUsers,
+			"comments":         {deleteComments, permLogin},
+			"users":            {deleteUsers, permUsers},
+			"polls":            {deletePolls, permPosts},
+			"reactions":        {deleteReactions, permLogin},
+			"invites":          {deleteInvites, permUsers},
+			"account/sessions": {deleteAccountSessions, permLogin},
+			"users/sessions":   {deleteUsersSessions, permUsers},
 		},
 	}
 
@@ -1129,16 +1494,21 @@ func init() {
 	}
 
 	for method, handlers := range endpoints {
-		for address, handler := range handlers {
+		for address, endpoint := range handlers {
 			handleMethods[method]("/api/"+address, func(c *fiber.Ctx) error {
 				logger.Sugar().Debugf("HTTP %s request: %q", c.Method(), c.OriginalURL())
 
 				var response responseMessage
 
-				if ok, err := checkUser(c); err != nil {
+				refreshExpiredSession(c)
+
+				// check wether the session is valid and the user has the permission for the endpoint
+				if ok, err := checkPermission(c, endpoint.permission); err == errInvalidSession {
+					response.Status = fiber.StatusUnauthorized
+				} else if err != nil {
 					response.Status = fiber.StatusInternalServerError
 				} else if ok {
-					response = handler(c)
+					response = endpoint.handler(c)
 				} else {
 					response.Status = fiber.StatusForbidden
 				}
@@ -1149,8 +1519,30 @@ func init() {
 	}
 }
 
+// command-line-tools, that are run instead of the server
+var commands = map[string]func(args []string) error{
+	"import-users": runImportUsers,
+	"rotate-keys":  runRotateKeys,
+}
+
 func main() {
 	defer logger.Sync()
 
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+			os.Exit(1)
+		} else if err := command(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	go purgeTrashPeriodically()
+	go purgeExpiredPeriodically()
+	go keys.reloadPeriodically()
+
 	app.Listen(fmt.Sprintf(":%d", Config.Server.Port))
 }
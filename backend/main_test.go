@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql/driver"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"go.uber.org/zap"
+)
+
+// the config is read by init() from the working-directory, so the tests run in "testdata";
+// package-variables are initialized before any init()
+var _ = func() bool {
+	if err := os.Chdir("testdata"); err != nil {
+		panic(err)
+	}
+
+	return true
+}()
+
+func TestMain(m *testing.M) {
+	// the tests don't write to "logs/server.log"
+	logger = *zap.NewNop()
+
+	os.Exit(m.Run())
+}
+
+// replaces the database with a mock for the duration of the test
+func mockDatabase(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	mockDb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := db
+	db = mockDb
+
+	t.Cleanup(func() {
+		db = previous
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+
+		mockDb.Close()
+	})
+
+	return mock
+}
+
+// creates the result-rows of a "dbSelect[T]" from the entries
+func mockRows[T any](entries ...T) *sqlmock.Rows {
+	tType := reflect.TypeOf(new(T)).Elem()
+	columns := []string{}
+
+	for ii := 0; ii < tType.NumField(); ii++ {
+		if column := dbColumn(tType.Field(ii)); column != "-" {
+			columns = append(columns, column)
+		}
+	}
+
+	rows := sqlmock.NewRows(columns)
+
+	for _, entry := range entries {
+		v := reflect.ValueOf(entry)
+		values := []driver.Value{}
+
+		for ii := 0; ii < tType.NumField(); ii++ {
+			if dbColumn(tType.Field(ii)) != "-" {
+				values = append(values, mockValue(v.Field(ii)))
+			}
+		}
+
+		rows.AddRow(values...)
+	}
+
+	return rows
+}
+
+// converts a struct-field into the value the database would return
+func mockValue(field reflect.Value) driver.Value {
+	if valuer, ok := field.Interface().(driver.Valuer); ok {
+		value, _ := valuer.Value()
+
+		return value
+	}
+
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return nil
+		}
+
+		field = field.Elem()
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		return field.String()
+	case reflect.Bool:
+		return field.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return field.Int()
+	case reflect.Slice:
+		return field.Bytes()
+	default:
+		return field.Interface()
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// moderation-states of comments
+const (
+	commentApproved = "approved"
+	commentPending  = "pending"
+	commentRejected = "rejected"
+)
+
+// returns the state of a new or edited comment
+func newCommentState() string {
+	if Config.Comments.Moderation {
+		return commentPending
+	} else {
+		return commentApproved
+	}
+}
+
+func getCommentsPending(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permComments); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+	} else if comments, err := dbSelect[Comment]("comments", "state = ? AND deleted IS NULL ORDER BY created", commentPending); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = comments
+	}
+
+	return response
+}
+
+func getCommentsPendingCount(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permComments); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+	} else if comments, err := dbSelect[struct{ Cid int }]("comments", "state = ? AND deleted IS NULL", commentPending); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = struct {
+			Count int `json:"count"`
+		}{
+			Count: len(comments),
+		}
+	}
+
+	return response
+}
+
+// sets the moderation-state of the comment given in the query
+func moderateComment(c *fiber.Ctx, state string, reason *string) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permComments); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+	} else if cid := c.QueryInt("cid", -1); cid < 0 {
+		logger.Info(`query doesn't include valid "cid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if comments, err := dbSelect[struct{ Cid int }]("comments", "cid = ? AND deleted IS NULL", cid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(comments) != 1 {
+		logger.Sugar().Infof("comment %d doesn't exist", cid)
+		response.Status = fiber.StatusBadRequest
+	} else if _, err := db.Exec("UPDATE comments SET state = ?, reason = ? WHERE cid = ?", state, reason, cid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		logger.Sugar().Infof("comment %d was %s", cid, state)
+
+		response = getCommentsPending(c)
+	}
+
+	return response
+}
+
+func postCommentsApprove(c *fiber.Ctx) responseMessage {
+	return moderateComment(c, commentApproved, nil)
+}
+
+func postCommentsReject(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Reason string `json:"reason"`
+	})
+
+	if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ reason string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else {
+		response = moderateComment(c, commentRejected, &body.Reason)
+	}
+
+	return response
+}
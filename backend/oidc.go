@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// time the user has to log in at the identity-provider
+const oidcLoginExpire = 10 * time.Minute
+
+const oidcPendingCookie = "oidc_pending"
+
+// state of a started login, stored in a signed cookie until the callback
+type OidcPending struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// prevents the token from being accepted as session
+	Stage string `json:"stage"`
+}
+
+type OidcPendingJWT struct {
+	Payload
+	CustomClaims OidcPending
+}
+
+var errOidcUnknownUser = fmt.Errorf("no account is linked to this login")
+
+var oidcClient struct {
+	mutex    sync.Mutex
+	provider *oidc.Provider
+}
+
+// discovers the identity-provider on first use, so the server starts even if it is unreachable
+func oidcConfig() (*oidc.Provider, oauth2.Config, error) {
+	oidcClient.mutex.Lock()
+	defer oidcClient.mutex.Unlock()
+
+	if oidcClient.provider == nil {
+		// the context is kept by the provider for fetching its keys later
+		if provider, err := oidc.NewProvider(context.Background(), Config.Oidc.Issuer); err != nil {
+			return nil, oauth2.Config{}, err
+		} else {
+			oidcClient.provider = provider
+		}
+	}
+
+	return oidcClient.provider, oauth2.Config{
+		ClientID:     Config.Oidc.ClientId,
+		ClientSecret: Config.Oidc.ClientSecret,
+		RedirectURL:  Config.Oidc.RedirectUrl,
+		Endpoint:     oidcClient.provider.Endpoint(),
+		Scopes:       Config.Oidc.Scopes,
+	}, nil
+}
+
+func newOidcPending() (OidcPending, error) {
+	values := make([]string, 2)
+
+	for ii := range values {
+		random := make([]byte, 32)
+
+		if _, err := rand.Read(random); err != nil {
+			return OidcPending{}, err
+		}
+
+		values[ii] = base64.RawURLEncoding.EncodeToString(random)
+	}
+
+	return OidcPending{
+		State:    values[0],
+		Nonce:    values[1],
+		Verifier: oauth2.GenerateVerifier(),
+		Stage:    "oidc",
+	}, nil
+}
+
+// redirects back to the calendar, errors are passed in the fragment
+func oidcRedirect(c *fiber.Ctx, loginError string) error {
+	target := strings.TrimSuffix(Config.Server.Url, "/") + "/"
+
+	if loginError != "" {
+		target += "#" + url.Values{"login_error": {loginError}}.Encode()
+	}
+
+	return c.Redirect(target)
+}
+
+// starts the authorization-code-flow by redirecting to the identity-provider
+func handleOidcLogin(c *fiber.Ctx) error {
+	if !Config.Oidc.Enabled {
+		return responseMessage{Status: fiber.StatusNotFound}.send(c)
+	}
+
+	_, config, err := oidcConfig()
+	if err != nil {
+		logger.Sugar().Errorf("can't reach identity-provider: %v", err)
+
+		return oidcRedirect(c, "identity-provider unavailable")
+	}
+
+	pending, err := newOidcPending()
+	if err != nil {
+		logger.Sugar().Error(err.Error())
+
+		return responseMessage{Status: fiber.StatusInternalServerError}.send(c)
+	}
+
+	token, err := Config.signJWTExpiring(pending, oidcLoginExpire)
+	if err != nil {
+		logger.Sugar().Errorf("failed creating json-webtoken: %v", err.Error())
+
+		return responseMessage{Status: fiber.StatusInternalServerError}.send(c)
+	}
+
+	// the callback is a cross-site navigation from the identity-provider, so the cookie can't be strict
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcPendingCookie,
+		Value:    token,
+		HTTPOnly: true,
+		SameSite: "lax",
+		MaxAge:   int(oidcLoginExpire.Seconds()),
+	})
+
+	return c.Redirect(config.AuthCodeURL(pending.State, oidc.Nonce(pending.Nonce), oauth2.S256ChallengeOption(pending.Verifier)))
+}
+
+// finishes the login after the identity-provider redirected back
+func handleOidcCallback(c *fiber.Ctx) error {
+	if !Config.Oidc.Enabled {
+		return responseMessage{Status: fiber.StatusNotFound}.send(c)
+	}
+
+	var claims OidcPendingJWT
+
+	token, err := jwt.ParseWithClaims(c.Cookies(oidcPendingCookie), &claims, keys.verificationKey)
+
+	c.ClearCookie(oidcPendingCookie)
+
+	pending := claims.CustomClaims
+
+	if err != nil || !token.Valid || pending.Stage != "oidc" || subtle.ConstantTimeCompare([]byte(pending.State), []byte(c.Query("state"))) != 1 {
+		logger.Sugar().Infof("oidc-callback from %s without matching login", c.IP())
+
+		return oidcRedirect(c, "login expired, please try again")
+	} else if loginError := c.Query("error"); loginError != "" {
+		logger.Sugar().Infof("identity-provider returned error %q: %s", loginError, c.Query("error_description"))
+
+		return oidcRedirect(c, "login was cancelled")
+	}
+
+	provider, config, err := oidcConfig()
+	if err != nil {
+		logger.Sugar().Errorf("can't reach identity-provider: %v", err)
+
+		return oidcRedirect(c, "identity-provider unavailable")
+	}
+
+	ctx := c.UserContext()
+
+	if oauthToken, err := config.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(pending.Verifier)); err != nil {
+		logger.Sugar().Warnf("can't exchange oidc-code: %v", err)
+	} else if rawIdToken, ok := oauthToken.Extra("id_token").(string); !ok {
+		logger.Warn("oidc-response doesn't contain an id-token")
+	} else if idToken, err := provider.Verifier(&oidc.Config{ClientID: Config.Oidc.ClientId}).Verify(ctx, rawIdToken); err != nil {
+		logger.Sugar().Warnf("invalid id-token: %v", err)
+	} else if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(pending.Nonce)) != 1 {
+		logger.Warn("id-token has the wrong nonce")
+	} else {
+		idClaims := map[string]any{}
+
+		if err := idToken.Claims(&idClaims); err != nil {
+			logger.Sugar().Warnf("can't parse id-token-claims: %v", err)
+		} else if user, err := oidcUser(idToken.Subject, idClaims); err == errOidcUnknownUser {
+			logger.Sugar().Infof("oidc-login of unknown subject %q", idToken.Subject)
+
+			return oidcRedirect(c, err.Error())
+		} else if err != nil {
+			logger.Sugar().Errorf("can't get user for oidc-login: %v", err)
+
+			// users with two-factor-authentication have to enter a code first, like with the other logins
+		} else if user.TotpEnabled {
+			if response := startTwoFactorLogin(c, user); response.Status != 0 && response.Status != fiber.StatusOK {
+				logger.Sugar().Errorf("can't start second login-step of user %d", user.Uid)
+			} else {
+				logger.Sugar().Infof("oidc-login of user %d awaits the second factor", user.Uid)
+
+				// the client asks for the code, when the fragment contains the pending step
+				return c.Redirect(strings.TrimSuffix(Config.Server.Url, "/") + "/#" + url.Values{"login_step": {"2fa"}}.Encode())
+			}
+		} else if response := completeLogin(c, user); response.Status != 0 && response.Status != fiber.StatusOK {
+			logger.Sugar().Errorf("can't complete oidc-login of user %d", user.Uid)
+		} else {
+			logger.Sugar().Infof("user %d logged in with oidc", user.Uid)
+
+			return oidcRedirect(c, "")
+		}
+	}
+
+	return oidcRedirect(c, "login failed")
+}
+
+// finds the local user of an identity-provider-account: by its subject, by its email-address or by creating it
+func oidcUser(subject string, claims map[string]any) (User, error) {
+	if users, err := dbSelect[User]("users", "oidc_subject = ? AND deleted IS NULL LIMIT 1", subject); err != nil {
+		return User{}, err
+	} else if len(users) == 1 {
+		return users[0], nil
+	}
+
+	email, _ := claims["email"].(string)
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	// unverified addresses could be chosen freely at the identity-provider
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		email = ""
+	}
+
+	if Config.Oidc.LinkByEmail && email != "" {
+		if users, err := dbSelect[User]("users", "email = ? AND oidc_subject IS NULL AND deleted IS NULL LIMIT 1", email); err != nil {
+			return User{}, err
+		} else if len(users) == 1 && users[0].Role != roleMember {
+			// whoever controls the address at the identity-provider would get the permissions of the role
+			logger.Sugar().Warnf("didn't link user %d with role %q to oidc-subject %q by email, administrative accounts aren't linked automatically", users[0].Uid, users[0].Role, subject)
+
+			return User{}, errOidcUnknownUser
+		} else if len(users) == 1 {
+			if _, err := db.Exec("UPDATE users SET oidc_subject = ? WHERE uid = ?", subject, users[0].Uid); err != nil {
+				return User{}, err
+			}
+
+			logger.Sugar().Warnf("linked user %d with oidc-subject %q by email", users[0].Uid, subject)
+
+			return users[0], nil
+		}
+	}
+
+	if Config.Oidc.Provision == "" {
+		return User{}, errOidcUnknownUser
+	}
+
+	return provisionOidcUser(subject, email, claims)
+}
+
+// creates a user for an identity-provider-account
+func provisionOidcUser(subject, email string, claims map[string]any) (User, error) {
+	baseName, _ := claims[Config.Oidc.NameClaim].(string)
+	baseName = strings.TrimSpace(baseName)
+
+	if baseName == "" {
+		baseName = "user"
+	}
+
+	// leave space for the suffix
+	if runes := []rune(baseName); len(runes) > maxUserNameLength-4 {
+		baseName = string(runes[:maxUserNameLength-4])
+	}
+
+	// the user-names are unique, so a number is appended if necessary
+	name := baseName
+
+	for ii := 2; ; ii++ {
+		if count, err := dbCount("users", struct{ Name string }{Name: name}); err != nil {
+			return User{}, err
+		} else if count == 0 {
+			break
+		}
+
+		name = baseName + " " + strconv.Itoa(ii)
+	}
+
+	// the email-address is only stored, if no other user has it already
+	var storedEmail *string
+
+	if email != "" {
+		if count, err := dbCount("users", struct{ Email string }{Email: email}); err != nil {
+			return User{}, err
+		} else if count == 0 {
+			storedEmail = &email
+		}
+	}
+
+	// provisioned users can only log in with the identity-provider, until an admin sets a password
+	password, err := generatePassword(32)
+	if err != nil {
+		return User{}, err
+	}
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	if res, err := db.Exec("INSERT INTO users (name, role, password, email, oidc_subject) VALUES (?, ?, ?, ?, ?)", name, Config.Oidc.Provision, newSecret(hashedPassword), storedEmail, subject); err != nil {
+		return User{}, err
+	} else if uid, err := res.LastInsertId(); err != nil {
+		return User{}, err
+	} else if users, err := dbSelect[User]("users", "uid = ? LIMIT 1", uid); err != nil {
+		return User{}, err
+	} else if len(users) != 1 {
+		return User{}, fmt.Errorf("can't get provisioned user %d", uid)
+	} else {
+		logger.Sugar().Infof("provisioned user %q for oidc-subject %q", name, subject)
+
+		return users[0], nil
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testIdpSubject = "idp-user"
+
+// authorization-request as it was received by the identity-provider
+type testAuthorization struct {
+	challenge string
+	nonce     string
+}
+
+// minimal identity-provider with discovery, token-endpoint and keys
+type testIdp struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mutex          sync.Mutex
+	authorizations map[string]testAuthorization
+	exchanged      bool
+}
+
+func newTestIdp(t *testing.T) *testIdp {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &testIdp{
+		key:            key,
+		authorizations: map[string]testAuthorization{},
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/keys",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", idp.handleToken(t))
+
+	idp.Server = httptest.NewServer(mux)
+
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+// simulates the login of the user at the identity-provider and returns the code
+func (idp *testIdp) authorize(t *testing.T, authUrl string) (string, url.Values) {
+	t.Helper()
+
+	location, err := url.Parse(authUrl)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(authUrl, idp.URL+"/authorize?") {
+		t.Fatalf("redirected to %q instead of the identity-provider", authUrl)
+	}
+
+	query := location.Query()
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization-request without S256-challenge: %v", query)
+	} else if query.Get("state") == "" || query.Get("nonce") == "" {
+		t.Fatalf("authorization-request without state or nonce: %v", query)
+	}
+
+	code, err := generateRefreshSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp.mutex.Lock()
+	idp.authorizations[code] = testAuthorization{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+	}
+	idp.mutex.Unlock()
+
+	return code, query
+}
+
+// exchanges a code for an id-token, after checking the pkce-verifier against the challenge
+func (idp *testIdp) handleToken(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		idp.mutex.Lock()
+		authorization, ok := idp.authorizations[r.Form.Get("code")]
+		delete(idp.authorizations, r.Form.Get("code"))
+		idp.mutex.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+
+			return
+		}
+
+		now := time.Now()
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            idp.URL,
+			"sub":            testIdpSubject,
+			"aud":            Config.Oidc.ClientId,
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Minute).Unix(),
+			"nonce":          authorization.nonce,
+			"email":          "user@example.com",
+			"email_verified": true,
+		})
+		token.Header["kid"] = "test"
+
+		idToken, err := token.SignedString(idp.key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		idp.mutex.Lock()
+		idp.exchanged = true
+		idp.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	}
+}
+
+// points the oidc-config to the identity-provider for the duration of the test
+func useTestIdp(t *testing.T) *testIdp {
+	idp := newTestIdp(t)
+
+	previous := Config.Oidc.Issuer
+	Config.Oidc.Issuer = idp.URL
+	oidcClient.provider = nil
+
+	t.Cleanup(func() {
+		Config.Oidc.Issuer = previous
+		oidcClient.provider = nil
+	})
+
+	return idp
+}
+
+// sends a request to the app and adds the cookies
+func testRequest(t *testing.T, target string, cookies ...*http.Cookie) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func responseCookie(resp *http.Response, name string) *http.Cookie {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+
+	return nil
+}
+
+// starts the login and lets the identity-provider redirect back with a code
+func startOidcLogin(t *testing.T, idp *testIdp) (*http.Cookie, string, url.Values) {
+	t.Helper()
+
+	resp := testRequest(t, "/api/oidc/login")
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login: got status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	pending := responseCookie(resp, oidcPendingCookie)
+	if pending == nil || pending.Value == "" {
+		t.Fatal("login didn't set the pending-cookie")
+	}
+
+	code, query := idp.authorize(t, resp.Header.Get("Location"))
+
+	return pending, code, query
+}
+
+func TestOidcLoginRoundTrip(t *testing.T) {
+	idp := useTestIdp(t)
+	mock := mockDatabase(t)
+
+	pending, code, query := startOidcLogin(t, idp)
+
+	mock.ExpectQuery(`FROM users WHERE oidc_subject = \?`).
+		WithArgs(testIdpSubject).
+		WillReturnRows(mockRows(User{Uid: 3, Name: "user", Role: roleMember, Tid: 1}))
+	mock.ExpectExec(`INSERT INTO sessions`).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+
+	resp := testRequest(t, "/api/oidc/callback?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), pending)
+
+	if location := resp.Header.Get("Location"); location != Config.Server.Url+"/" {
+		t.Fatalf("callback redirected to %q", location)
+	} else if !idp.exchanged {
+		t.Fatal("the code wasn't exchanged")
+	}
+
+	if session := responseCookie(resp, "session"); session == nil || session.Value == "" {
+		t.Error("callback didn't set the session-cookie")
+	} else if claims, err := parseJWT(session.Value, &JWT{}); err != nil {
+		t.Errorf("invalid session-token: %v", err)
+	} else if payload := claims.Claims.(*JWT).CustomClaims; payload.Uid != 3 || payload.Sid != 7 {
+		t.Errorf("session-token for user %d and session %d", payload.Uid, payload.Sid)
+	}
+
+	if refresh := responseCookie(resp, "refresh"); refresh == nil || !strings.HasPrefix(refresh.Value, "7.") {
+		t.Error("callback didn't set the refresh-cookie")
+	}
+}
+
+func TestOidcCallbackRejectsWrongState(t *testing.T) {
+	idp := useTestIdp(t)
+	mockDatabase(t)
+
+	pending, code, _ := startOidcLogin(t, idp)
+
+	resp := testRequest(t, "/api/oidc/callback?"+url.Values{"code": {code}, "state": {"forged"}}.Encode(), pending)
+
+	if location := resp.Header.Get("Location"); !strings.Contains(location, "#login_error=") {
+		t.Errorf("callback redirected to %q", location)
+	} else if idp.exchanged {
+		t.Error("the code was exchanged despite the wrong state")
+	} else if responseCookie(resp, "session") != nil {
+		t.Error("callback set a session-cookie")
+	}
+}
+
+func TestOidcCallbackWithoutPendingLogin(t *testing.T) {
+	idp := useTestIdp(t)
+	mockDatabase(t)
+
+	_, code, query := startOidcLogin(t, idp)
+
+	// the state has to be bound to the browser, which started the login
+	resp := testRequest(t, "/api/oidc/callback?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode())
+
+	if location := resp.Header.Get("Location"); !strings.Contains(location, "#login_error=") {
+		t.Errorf("callback redirected to %q", location)
+	} else if idp.exchanged {
+		t.Error("the code was exchanged without a pending login")
+	}
+}
+
+func TestOidcLoginRequiresSecondFactor(t *testing.T) {
+	idp := useTestIdp(t)
+	mock := mockDatabase(t)
+
+	pending, code, query := startOidcLogin(t, idp)
+
+	mock.ExpectQuery(`FROM users WHERE oidc_subject = \?`).
+		WithArgs(testIdpSubject).
+		WillReturnRows(mockRows(User{Uid: 3, Name: "user", Role: roleMember, Tid: 1, TotpEnabled: true}))
+
+	resp := testRequest(t, "/api/oidc/callback?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), pending)
+
+	if location := resp.Header.Get("Location"); location != Config.Server.Url+"/#login_step=2fa" {
+		t.Errorf("callback redirected to %q", location)
+	} else if responseCookie(resp, "session") != nil {
+		t.Error("callback set a session-cookie before the second factor")
+	} else if responseCookie(resp, pendingLoginCookie) == nil {
+		t.Error("callback didn't start the second login-step")
+	}
+}
+
+func TestOidcLinkByEmailOnlyMembers(t *testing.T) {
+	previous := Config.Oidc.LinkByEmail
+	Config.Oidc.LinkByEmail = true
+
+	t.Cleanup(func() {
+		Config.Oidc.LinkByEmail = previous
+	})
+
+	for _, role := range []Role{roleMember, roleEditor, roleOwner} {
+		t.Run(string(role), func(t *testing.T) {
+			idp := useTestIdp(t)
+			mock := mockDatabase(t)
+
+			pending, code, query := startOidcLogin(t, idp)
+
+			email := "user@example.com"
+
+			mock.ExpectQuery(`FROM users WHERE oidc_subject = \?`).
+				WithArgs(testIdpSubject).
+				WillReturnRows(mockRows[User]())
+			mock.ExpectQuery(`FROM users WHERE email = \? AND oidc_subject IS NULL`).
+				WithArgs(email).
+				WillReturnRows(mockRows(User{Uid: 3, Name: "user", Role: role, Tid: 1, Email: &email}))
+
+			if role == roleMember {
+				mock.ExpectExec(`UPDATE users SET oidc_subject = \? WHERE uid = \?`).
+					WithArgs(testIdpSubject, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO sessions`).
+					WillReturnResult(sqlmock.NewResult(7, 1))
+			}
+
+			resp := testRequest(t, "/api/oidc/callback?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), pending)
+
+			if linked := responseCookie(resp, "session") != nil; linked != (role == roleMember) {
+				t.Errorf("got logged in %v, redirected to %q", linked, resp.Header.Get("Location"))
+			}
+		})
+	}
+}
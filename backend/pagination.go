@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// upper limit for the number of entries per page
+const maxPageSize = 500
+
+// keyset-pagination over an integer id-column
+type Page struct {
+	// number of entries per page, 0 is unlimited
+	Limit int
+	// id of the last entry of the previous page, 0 starts at the beginning
+	Cursor int
+	Desc   bool
+}
+
+// parses "limit", "cursor" and "order" from the query
+func parsePage(c *fiber.Ctx) (Page, error) {
+	page := Page{
+		Limit:  c.QueryInt("limit", 0),
+		Cursor: c.QueryInt("cursor", 0),
+	}
+
+	switch c.Query("order", "asc") {
+	case "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return page, fmt.Errorf(`invalid "order" %q`, c.Query("order"))
+	}
+
+	if page.Limit < 0 || page.Limit > maxPageSize {
+		return page, fmt.Errorf(`invalid "limit" %d`, page.Limit)
+	} else if page.Cursor < 0 {
+		return page, fmt.Errorf(`invalid "cursor" %d`, page.Cursor)
+	}
+
+	return page, nil
+}
+
+// appends the cursor-condition, the order and the limit to a where-clause
+func (page Page) query(column, where string, args []any) (string, []any) {
+	order := "ASC"
+	comparison := ">"
+
+	if page.Desc {
+		order = "DESC"
+		comparison = "<"
+	}
+
+	if page.Cursor > 0 {
+		where = fmt.Sprintf("%s AND %s %s ?", where, column, comparison)
+		args = append(args, page.Cursor)
+	}
+
+	where = fmt.Sprintf("%s ORDER BY %s %s", where, column, order)
+
+	// fetch one additional entry to know wether there is a next page
+	if page.Limit > 0 {
+		where += " LIMIT ?"
+		args = append(args, page.Limit+1)
+	}
+
+	return where, args
+}
+
+// cuts the additional entry and sends the cursor of the next page in the "X-Next-Cursor"-header
+func paginate[T any](c *fiber.Ctx, page Page, entries []T, id func(T) int) []T {
+	if page.Limit > 0 && len(entries) > page.Limit {
+		entries = entries[:page.Limit]
+
+		c.Set("X-Next-Cursor", strconv.Itoa(id(entries[len(entries)-1])))
+	}
+
+	return entries
+}
+
+// builds the where-clause for the comment-listing from the query-filters
+func commentFilterQuery(c *fiber.Ctx) (string, []any, error) {
+	conditions := []string{"deleted IS NULL"}
+	args := []any{}
+
+	if pid := c.QueryInt("pid", -1); pid >= 0 {
+		conditions = append(conditions, "pid = ?")
+		args = append(args, pid)
+	}
+
+	if uid := c.QueryInt("uid", -1); uid >= 0 {
+		conditions = append(conditions, "uid = ?")
+		args = append(args, uid)
+	}
+
+	// comments are answered if they have an (legacy) answer or a reply
+	answered := "(answer IS NOT NULL OR EXISTS (SELECT 1 FROM comments AS replies WHERE replies.parent = comments.cid AND replies.deleted IS NULL))"
+
+	switch c.Query("answered") {
+	case "":
+	case "true":
+		conditions = append(conditions, answered)
+	case "false":
+		conditions = append(conditions, "NOT "+answered)
+	default:
+		return "", nil, fmt.Errorf(`invalid "answered" %q`, c.Query("answered"))
+	}
+
+	if from := c.Query("from"); from != "" {
+		if date, err := time.ParseInLocation(time.DateOnly, from, time.Local); err != nil {
+			return "", nil, err
+		} else {
+			conditions = append(conditions, "created >= ?")
+			args = append(args, date)
+		}
+	}
+
+	// the to-date is inclusive
+	if to := c.Query("to"); to != "" {
+		if date, err := time.ParseInLocation(time.DateOnly, to, time.Local); err != nil {
+			return "", nil, err
+		} else {
+			conditions = append(conditions, "created < ?")
+			args = append(args, date.AddDate(0, 0, 1))
+		}
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// lists the comments of all posts for admins
+func listComments(c *fiber.Ctx, uid int) responseMessage {
+	var response responseMessage
+
+	page, err := parsePage(c)
+	if err != nil {
+		logger.Info(err.Error())
+		response.Status = fiber.StatusBadRequest
+
+		return response
+	}
+
+	where, args, err := commentFilterQuery(c)
+	if err != nil {
+		logger.Info(err.Error())
+		response.Status = fiber.StatusBadRequest
+
+		return response
+	}
+
+	where, args = page.query("cid", where, args)
+
+	if comments, err := dbSelect[Comment]("comments", where, args...); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if results, err := addCommentDetails(paginate(c, page, comments, func(comment Comment) int { return comment.Cid }), uid); err != nil {
+		logger.Sugar().Errorf("can't retrieve comment-details: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = results
+	}
+
+	return response
+}
+
+// lists the users, optionally filtered by their role and a name-search
+func listUsers(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	page, err := parsePage(c)
+	if err != nil {
+		logger.Info(err.Error())
+		response.Status = fiber.StatusBadRequest
+
+		return response
+	}
+
+	conditions := []string{"deleted IS NULL"}
+	args := []any{}
+
+	if role := Role(c.Query("role")); role != "" {
+		if !validRole(role) {
+			logger.Sugar().Infof(`invalid "role" %q`, role)
+			response.Status = fiber.StatusBadRequest
+
+			return response
+		}
+
+		conditions = append(conditions, "role = ?")
+		args = append(args, role)
+	}
+
+	if search := c.Query("q"); search != "" {
+		conditions = append(conditions, "(name LIKE ? OR display LIKE ?)")
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search) + "%"
+		args = append(args, pattern, pattern)
+	}
+
+	where, args := page.query("uid", strings.Join(conditions, " AND "), args)
+
+	if users, err := dbSelect[User]("users", where, args...); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = userInfos(paginate(c, page, users, func(user User) int { return user.Uid }))
+	}
+
+	return response
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type Poll struct {
+	Plid     int    `json:"plid"`
+	Pid      int    `json:"pid"`
+	Question string `json:"question"`
+	Multiple bool   `json:"multiple"`
+}
+
+type PollOption struct {
+	Oid  int    `json:"oid"`
+	Plid int    `json:"-"`
+	Text string `json:"text"`
+}
+
+type PollOptionResult struct {
+	PollOption
+	// only set if the user is allowed to see the results
+	Votes *int `json:"votes,omitempty"`
+}
+
+type PollResult struct {
+	Poll
+	Options []PollOptionResult `json:"options"`
+	// options the user voted for
+	Voted []int `json:"voted"`
+}
+
+// retrieves a poll with its options and, if the user is allowed to see them, the results
+func getPollResult(poll Poll, uid int, admin bool, postDate string) (PollResult, error) {
+	result := PollResult{
+		Poll:  poll,
+		Voted: []int{},
+	}
+
+	options, err := dbSelect[PollOption]("options", "plid = ?", poll.Plid)
+	if err != nil {
+		return result, err
+	}
+
+	if votes, err := dbSelect[struct{ Oid int }]("votes", "plid = ? AND uid = ?", poll.Plid, uid); err != nil {
+		return result, err
+	} else {
+		for _, vote := range votes {
+			result.Voted = append(result.Voted, vote.Oid)
+		}
+	}
+
+	// results are visible after voting or once the day of the post is over
+	showResults := admin || len(result.Voted) > 0 || postDate < time.Now().Format(time.DateOnly)
+
+	counts := map[int]int{}
+
+	if showResults {
+		rows, err := db.Query("SELECT oid, COUNT(*) FROM votes WHERE plid = ? GROUP BY oid", poll.Plid)
+		if err != nil {
+			return result, err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var oid, count int
+
+			if err := rows.Scan(&oid, &count); err != nil {
+				return result, err
+			}
+
+			counts[oid] = count
+		}
+
+		if err := rows.Err(); err != nil {
+			return result, err
+		}
+	}
+
+	result.Options = make([]PollOptionResult, len(options))
+
+	for ii, option := range options {
+		result.Options[ii].PollOption = option
+
+		if showResults {
+			result.Options[ii].Votes = ptr(counts[option.Oid])
+		}
+	}
+
+	return result, nil
+}
+
+func getPolls(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if pid := c.QueryInt("pid", -1); pid < 0 {
+		logger.Info(`query doesn't include valid "pid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if admin, err := checkPermission(c, permPosts); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if postDate, err := getPostDate(pid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if postDate == "" {
+		response.Status = fiber.StatusBadRequest
+	} else if polls, err := dbSelect[Poll]("polls", "pid = ?", pid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		results := make([]PollResult, len(polls))
+
+		for ii, poll := range polls {
+			if result, err := getPollResult(poll, uid, admin, postDate); err != nil {
+				logger.Sugar().Errorf("can't retrieve poll %d: %v", poll.Plid, err)
+				response.Status = fiber.StatusInternalServerError
+
+				return response
+			} else {
+				results[ii] = result
+			}
+		}
+
+		response.Data = results
+	}
+
+	return response
+}
+
+func postPolls(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permPosts); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		logger.Sugar().Warn("user lacks the permission")
+		response.Status = fiber.StatusForbidden
+	} else {
+		body := new(struct {
+			Question string   `json:"question"`
+			Multiple bool     `json:"multiple"`
+			Options  []string `json:"options"`
+		})
+
+		if pid := c.QueryInt("pid", -1); pid < 0 {
+			logger.Info(`query doesn't include valid "pid"`)
+			response.Status = fiber.StatusBadRequest
+		} else if err := c.BodyParser(&body); err != nil {
+			logger.Sugar().Warn(`"body" can't be parsed as "{ question string; multiple bool; options []string }"`)
+			response.Status = fiber.StatusBadRequest
+		} else if body.Question == "" || len(body.Options) < 2 {
+			logger.Info("poll needs a question and at least two options")
+			response.Status = fiber.StatusBadRequest
+		} else if postDate, err := getPostDate(pid); err != nil {
+			response.Status = fiber.StatusInternalServerError
+		} else if postDate == "" {
+			response.Status = fiber.StatusBadRequest
+		} else if tx, err := db.Begin(); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			defer tx.Rollback()
+
+			if res, err := tx.Exec("INSERT INTO polls (pid, question, multiple) VALUES (?, ?, ?)", pid, body.Question, body.Multiple); err != nil {
+				logger.Sugar().Errorf("can't create poll: %v", err)
+				response.Status = fiber.StatusInternalServerError
+			} else if plid, err := res.LastInsertId(); err != nil {
+				logger.Sugar().Error(err.Error())
+				response.Status = fiber.StatusInternalServerError
+			} else {
+				for _, option := range body.Options {
+					if _, err := tx.Exec("INSERT INTO options (plid, text) VALUES (?, ?)", plid, option); err != nil {
+						logger.Sugar().Errorf("can't create poll-option: %v", err)
+						response.Status = fiber.StatusInternalServerError
+
+						return response
+					}
+				}
+
+				if err := tx.Commit(); err != nil {
+					logger.Sugar().Error(err.Error())
+					response.Status = fiber.StatusInternalServerError
+				} else {
+					response = getPolls(c)
+				}
+			}
+		}
+	}
+
+	return response
+}
+
+func deletePolls(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permPosts); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		logger.Sugar().Warn("user lacks the permission")
+		response.Status = fiber.StatusForbidden
+	} else if plid := c.QueryInt("plid", -1); plid < 0 {
+		logger.Info(`query doesn't include valid "plid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if tx, err := db.Begin(); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		defer tx.Rollback()
+
+		// the poll is only deleted together with its options and votes
+		for _, table := range []string{"votes", "options", "polls"} {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE plid = ?", table), plid); err != nil {
+				logger.Sugar().Warnf("Deleting poll from %q failed with error: %v", table, err.Error())
+				response.Status = fiber.StatusInternalServerError
+
+				return response
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		}
+	}
+
+	return response
+}
+
+func postPollsVote(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Options []int `json:"options"`
+	})
+
+	if plid := c.QueryInt("plid", -1); plid < 0 {
+		logger.Info(`query doesn't include valid "plid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ options []int }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if polls, err := dbSelect[Poll]("polls", "plid = ? LIMIT 1", plid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(polls) != 1 {
+		logger.Sugar().Infof("poll %d doesn't exist", plid)
+		response.Status = fiber.StatusBadRequest
+	} else if postDate, err := getPostDate(polls[0].Pid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+
+		// only allow voting while the door is active
+	} else if postDate != time.Now().Format(time.DateOnly) {
+		response.Status = fiber.StatusForbidden
+	} else if options, err := dbSelect[PollOption]("options", "plid = ?", plid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		poll := polls[0]
+
+		// validate the selected options
+		slices.Sort(body.Options)
+		body.Options = slices.Compact(body.Options)
+
+		if len(body.Options) == 0 || (!poll.Multiple && len(body.Options) != 1) {
+			logger.Sugar().Infof("invalid number of options for poll %d", plid)
+			response.Status = fiber.StatusBadRequest
+
+			return response
+		}
+
+		for _, oid := range body.Options {
+			if !slices.ContainsFunc(options, func(option PollOption) bool { return option.Oid == oid }) {
+				logger.Sugar().Infof("option %d doesn't belong to poll %d", oid, plid)
+				response.Status = fiber.StatusBadRequest
+
+				return response
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+
+			return response
+		}
+
+		defer tx.Rollback()
+
+		// check wether the user already voted
+		var count int
+
+		if err := tx.QueryRow("SELECT COUNT(*) FROM votes WHERE plid = ? AND uid = ? FOR UPDATE", plid, uid).Scan(&count); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else if count != 0 {
+			response.Status = fiber.StatusConflict
+		} else {
+			for _, oid := range body.Options {
+				if _, err := tx.Exec("INSERT INTO votes (plid, oid, uid) VALUES (?, ?, ?)", plid, oid, uid); err != nil {
+					logger.Sugar().Warnf("Writing vote to database failed with error: %v", err.Error())
+					response.Status = fiber.StatusInternalServerError
+
+					return response
+				}
+			}
+
+			if err := tx.Commit(); err != nil {
+				logger.Sugar().Error(err.Error())
+				response.Status = fiber.StatusInternalServerError
+			} else if result, err := getPollResult(poll, uid, false, postDate); err != nil {
+				logger.Sugar().Error(err.Error())
+				response.Status = fiber.StatusInternalServerError
+			} else {
+				response.Data = result
+			}
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/rand"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// failed login-attempts of an ip-address or an username
+type loginAttempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// an account that was locked because of too many failed logins
+type LoginLockout struct {
+	Name  string    `json:"name"`
+	Ip    string    `json:"ip"`
+	Until time.Time `json:"until"`
+}
+
+type loginLimiter struct {
+	mutex    sync.Mutex
+	attempts map[string]*loginAttempts
+	lockouts []LoginLockout
+}
+
+var limiter = loginLimiter{
+	attempts: map[string]*loginAttempts{},
+}
+
+// hash that is compared against for unknown users, so they take as long as known ones
+var dummyPasswordHash []byte
+
+func init() {
+	password := make([]byte, 32)
+	rand.Read(password)
+
+	dummyPasswordHash, _ = bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+}
+
+// the database compares the names case-insensitive and ignores trailing spaces,
+// so all spellings of a name have to share the same attempts
+func userKey(name string) string {
+	return "user:" + strings.ToLower(strings.TrimSpace(name))
+}
+
+// returns the time until which the attempts are blocked
+func (attempts loginAttempts) blockedUntil() time.Time {
+	if attempts.failures == 0 {
+		return time.Time{}
+	}
+
+	// double the delay with every failure
+	backoff := time.Duration(float64(Config.LoginBackoff) * math.Pow(2, float64(attempts.failures-1)))
+
+	if backoff > Config.MaxBackoff || backoff < 0 {
+		backoff = Config.MaxBackoff
+	}
+
+	until := attempts.last.Add(backoff)
+
+	if attempts.lockedUntil.After(until) {
+		until = attempts.lockedUntil
+	}
+
+	return until
+}
+
+// returns the remaining time until the next login-attempt is allowed
+func (limiter *loginLimiter) wait(ip, name string, now time.Time) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	var wait time.Duration
+
+	for _, key := range []string{"ip:" + ip, userKey(name)} {
+		if attempts, ok := limiter.attempts[key]; ok {
+			if remaining := attempts.blockedUntil().Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+
+	return wait
+}
+
+// registers a failed login and locks the account after too many failures
+func (limiter *loginLimiter) fail(ip, name string, now time.Time) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.cleanup(now)
+
+	for _, key := range []string{"ip:" + ip, userKey(name)} {
+		attempts, ok := limiter.attempts[key]
+		if !ok {
+			attempts = &loginAttempts{}
+			limiter.attempts[key] = attempts
+		}
+
+		attempts.failures++
+		attempts.last = now
+	}
+
+	if attempts := limiter.attempts[userKey(name)]; Config.Login.MaxFailures > 0 && attempts.failures%Config.Login.MaxFailures == 0 {
+		attempts.lockedUntil = now.Add(Config.LoginLockout)
+
+		limiter.lockouts = append(limiter.lockouts, LoginLockout{
+			Name:  name,
+			Ip:    ip,
+			Until: attempts.lockedUntil,
+		})
+
+		logger.Sugar().Warnf("account %q locked until %s after %d failed logins (last from %s)", name, attempts.lockedUntil.Format(time.DateTime), attempts.failures, ip)
+	}
+}
+
+// resets the failed attempts of the user after a successful login,
+// the ip-address keeps its attempts, so a login into an own account doesn't reset the backoff for guessing others
+func (limiter *loginLimiter) succeed(name string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	delete(limiter.attempts, userKey(name))
+}
+
+// removes attempts and lockouts that don't block anymore
+func (limiter *loginLimiter) cleanup(now time.Time) {
+	for key, attempts := range limiter.attempts {
+		if now.Sub(attempts.last) > Config.MaxBackoff && now.After(attempts.lockedUntil) {
+			delete(limiter.attempts, key)
+		}
+	}
+
+	lockouts := []LoginLockout{}
+
+	for _, lockout := range limiter.lockouts {
+		if now.Before(lockout.Until) {
+			lockouts = append(lockouts, lockout)
+		}
+	}
+
+	limiter.lockouts = lockouts
+}
+
+// checks wether a login is allowed and sets the "Retry-After"-header otherwise
+func checkLoginAllowed(c *fiber.Ctx, name string) bool {
+	if wait := limiter.wait(c.IP(), name, time.Now()); wait > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+		logger.Sugar().Infof("login of %q from %s throttled for %s", name, c.IP(), wait.Round(time.Second))
+
+		return false
+	}
+
+	return true
+}
+
+func getLoginLockouts(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permUsers); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+	} else {
+		limiter.mutex.Lock()
+		limiter.cleanup(time.Now())
+
+		lockouts := append([]LoginLockout{}, limiter.lockouts...)
+
+		limiter.mutex.Unlock()
+
+		sort.Slice(lockouts, func(ii, jj int) bool { return lockouts[ii].Until.After(lockouts[jj].Until) })
+
+		response.Data = lockouts
+	}
+
+	return response
+}
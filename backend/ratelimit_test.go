@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestLimiter() *loginLimiter {
+	return &loginLimiter{
+		attempts: map[string]*loginAttempts{},
+	}
+}
+
+func TestLimiterSharesAttemptsOfNameSpellings(t *testing.T) {
+	limiter := newTestLimiter()
+	now := time.Now()
+
+	// the database finds the same user for all of these names
+	for _, name := range []string{"Alice", "alice", "alice ", " ALICE"} {
+		limiter.fail("192.0.2.1", name, now)
+	}
+
+	if attempts := limiter.attempts[userKey("alice")]; attempts == nil || attempts.failures != 4 {
+		t.Fatalf("got attempts %+v, want 4 failures", attempts)
+	}
+
+	// the backoff applies to every spelling, also from another address
+	if wait := limiter.wait("192.0.2.2", "ALICE", now); wait <= 0 {
+		t.Error("other spelling of the name isn't throttled")
+	}
+}
+
+func TestLimiterLocksAccountAfterMaxFailures(t *testing.T) {
+	limiter := newTestLimiter()
+	now := time.Now()
+
+	for ii := 0; ii < Config.Login.MaxFailures; ii++ {
+		// every attempt comes from another address with another spelling
+		limiter.fail(fmt.Sprintf("192.0.2.%d", ii+1), []string{"Alice", "alice "}[ii%2], now)
+	}
+
+	if len(limiter.lockouts) != 1 {
+		t.Fatalf("got %d lockouts, want 1", len(limiter.lockouts))
+	} else if wait := limiter.wait("198.51.100.1", "alice", now); wait < Config.LoginLockout {
+		t.Errorf("locked account can be used again after %s", wait)
+	}
+}
+
+func TestLimiterSucceedKeepsAddressBackoff(t *testing.T) {
+	limiter := newTestLimiter()
+	now := time.Now()
+
+	limiter.fail("192.0.2.1", "victim", now)
+	limiter.fail("192.0.2.1", "victim", now)
+
+	// a login into an own account from the same address
+	limiter.succeed("attacker")
+
+	if wait := limiter.wait("192.0.2.1", "victim", now); wait <= 0 {
+		t.Error("login into another account reset the backoff of the address")
+	}
+
+	limiter.succeed("victim")
+
+	if _, ok := limiter.attempts[userKey("victim")]; ok {
+		t.Error("successful login didn't reset the attempts of the user")
+	} else if _, ok := limiter.attempts["ip:192.0.2.1"]; !ok {
+		t.Error("successful login reset the attempts of the address")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Me    bool   `json:"me"`
+}
+
+// checks wether a string consists of a single emoji (-sequence)
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > 32 || utf8.RuneCountInString(emoji) > 8 {
+		return false
+	}
+
+	symbol := false
+
+	for _, r := range emoji {
+		switch {
+		case unicode.Is(unicode.So, r):
+			symbol = true
+		// modifiers, variation-selectors, zero-width-joiners and keycaps
+		case unicode.In(r, unicode.Sk, unicode.Mn, unicode.Me, unicode.Cf), r == '#', r == '*', unicode.IsDigit(r):
+		default:
+			return false
+		}
+	}
+
+	return symbol
+}
+
+// retrieves the aggregated reactions for the given pids or cids
+func getReactionCounts(column string, ids []int, uid int) (map[int][]ReactionCount, error) {
+	result := map[int][]ReactionCount{}
+
+	if column != "pid" && column != "cid" {
+		return nil, fmt.Errorf("invalid reaction-column %q", column)
+	} else if len(ids) == 0 {
+		return result, nil
+	}
+
+	args := []any{uid}
+
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	rows, err := db.Query(fmt.Sprintf("SELECT %[1]s, emoji, COUNT(*), SUM(uid = ?) FROM reactions WHERE %[1]s IN (%[2]s) GROUP BY %[1]s, emoji ORDER BY MIN(rid)", column, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var reaction ReactionCount
+		var me int
+
+		if err := rows.Scan(&id, &reaction.Emoji, &reaction.Count, &me); err != nil {
+			return nil, err
+		}
+
+		reaction.Me = me > 0
+
+		result[id] = append(result[id], reaction)
+	}
+
+	return result, rows.Err()
+}
+
+// parses the reaction-target ("pid" or "cid") from the query
+func reactionTarget(c *fiber.Ctx) (string, int, error) {
+	pid := c.QueryInt("pid", -1)
+	cid := c.QueryInt("cid", -1)
+
+	switch {
+	case pid >= 0 && cid < 0:
+		if postDate, err := getPostDate(pid); err != nil {
+			return "", 0, err
+		} else if postDate == "" || postDate > time.Now().Format(time.DateOnly) {
+			return "", 0, fiber.ErrBadRequest
+		}
+
+		return "pid", pid, nil
+	case cid >= 0 && pid < 0:
+		if comments, err := dbSelect[Comment]("comments", "cid = ? AND deleted IS NULL", cid); err != nil {
+			return "", 0, err
+		} else if len(comments) != 1 {
+			return "", 0, fiber.ErrBadRequest
+		} else if uid, _, err := extractJWT(c); err != nil {
+			return "", 0, err
+		} else if admin, err := checkPermission(c, permComments); err != nil {
+			return "", 0, err
+		} else if admin {
+			return "cid", cid, nil
+
+			// users can only react to comments they can see
+		} else if comments[0].State != commentApproved && comments[0].Uid != uid {
+			return "", 0, fiber.ErrNotFound
+		} else if visible, err := threadVisible(comments[0], uid); err != nil {
+			return "", 0, err
+		} else if !visible {
+			return "", 0, fiber.ErrNotFound
+		}
+
+		return "cid", cid, nil
+	default:
+		return "", 0, fiber.ErrBadRequest
+	}
+}
+
+func getReactions(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if column, id, err := reactionTarget(c); err == fiber.ErrBadRequest {
+		logger.Info(`query doesn't include valid "pid" or "cid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if err == fiber.ErrNotFound {
+		logger.Sugar().Infof("reaction-target %s isn't visible", c.OriginalURL())
+		response.Status = fiber.StatusNotFound
+	} else if err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if reactions, err := getReactionCounts(column, []int{id}, uid); err != nil {
+		logger.Sugar().Errorf("can't retrieve reactions: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else if reactions[id] == nil {
+		response.Data = []ReactionCount{}
+	} else {
+		response.Data = reactions[id]
+	}
+
+	return response
+}
+
+func postReactions(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Emoji string `json:"emoji"`
+	})
+
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if column, id, err := reactionTarget(c); err == fiber.ErrBadRequest {
+		logger.Info(`query doesn't include valid "pid" or "cid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if err == fiber.ErrNotFound {
+		logger.Sugar().Infof("reaction-target %s isn't visible", c.OriginalURL())
+		response.Status = fiber.StatusNotFound
+	} else if err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ emoji string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if !validEmoji(body.Emoji) {
+		logger.Sugar().Infof("invalid emoji %q", body.Emoji)
+		response.Status = fiber.StatusBadRequest
+	} else if _, err := db.Exec(fmt.Sprintf("INSERT IGNORE INTO reactions (%s, uid, emoji) VALUES (?, ?, ?)", column), id, uid, body.Emoji); err != nil {
+		logger.Sugar().Warnf("Writing reaction to database failed with error: %v", err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response = getReactions(c)
+	}
+
+	return response
+}
+
+func deleteReactions(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if column, id, err := reactionTarget(c); err == fiber.ErrBadRequest {
+		logger.Info(`query doesn't include valid "pid" or "cid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if err == fiber.ErrNotFound {
+		logger.Sugar().Infof("reaction-target %s isn't visible", c.OriginalURL())
+		response.Status = fiber.StatusNotFound
+	} else if err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if emoji := c.Query("emoji"); emoji == "" {
+		logger.Info(`query doesn't include valid "emoji"`)
+		response.Status = fiber.StatusBadRequest
+	} else if _, err := db.Exec(fmt.Sprintf("DELETE FROM reactions WHERE %s = ? AND uid = ? AND emoji = ?", column), id, uid, emoji); err != nil {
+		logger.Sugar().Warnf("Deleting reaction from database failed with error: %v", err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response = getReactions(c)
+	}
+
+	return response
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// retrieves the cids of a comment and all its (nested) replies
+func getCommentThread(cid int) ([]int, error) {
+	thread := []int{cid}
+	level := []any{cid}
+
+	for len(level) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(level)), ", ")
+
+		replies, err := dbSelect[struct{ Cid int }]("comments", fmt.Sprintf("parent IN (%s)", placeholders), level...)
+		if err != nil {
+			return nil, err
+		}
+
+		level = level[:0]
+
+		for _, reply := range replies {
+			thread = append(thread, reply.Cid)
+			level = append(level, reply.Cid)
+		}
+	}
+
+	return thread, nil
+}
+
+// retrieves the nesting-depth of a comment, top-level comments have a depth of 0
+func getCommentDepth(comment Comment) (int, error) {
+	depth := 0
+
+	for comment.Parent != 0 {
+		depth++
+
+		if depth > Config.Comments.MaxDepth {
+			break
+		} else if parents, err := dbSelect[Comment]("comments", "cid = ? LIMIT 1", comment.Parent); err != nil {
+			return 0, err
+		} else if len(parents) != 1 {
+			return 0, fmt.Errorf("parent %d of comment %d doesn't exist", comment.Parent, comment.Cid)
+		} else {
+			comment = parents[0]
+		}
+	}
+
+	return depth, nil
+}
+
+// adds a reply of the current user to a comment and sends the comments of the post
+func addReply(c *fiber.Ctx, cid int, text string) responseMessage {
+	var response responseMessage
+
+	if uid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if parents, err := dbSelect[Comment]("comments", "cid = ? AND deleted IS NULL LIMIT 1", cid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(parents) != 1 {
+		logger.Sugar().Infof("comment %d doesn't exist", cid)
+		response.Status = fiber.StatusBadRequest
+	} else if admin, err := checkPermission(c, permComments); err != nil {
+		response.Status = fiber.StatusInternalServerError
+
+		// only allow replies to comments the user can see
+	} else if !admin && parents[0].State != commentApproved && parents[0].Uid != uid {
+		response.Status = fiber.StatusForbidden
+	} else if visible, err := threadVisible(parents[0], uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+
+		// private threads of other users aren't revealed
+	} else if !admin && !visible {
+		logger.Sugar().Infof("thread of comment %d isn't visible to user %d", cid, uid)
+		response.Status = fiber.StatusNotFound
+	} else if depth, err := getCommentDepth(parents[0]); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if depth >= Config.Comments.MaxDepth {
+		logger.Sugar().Infof("maximum reply-depth reached for comment %d", cid)
+		response.Status = fiber.StatusForbidden
+	} else if postDate, err := getPostDate(parents[0].Pid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+
+		// only allow replies on opened doors
+	} else if postDate > time.Now().Format(time.DateOnly) {
+		response.Status = fiber.StatusForbidden
+	} else if rules, _, err := getCommentRules(parents[0].Pid); err != nil {
+		logger.Sugar().Errorf("can't retrieve comment-rules: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else if !rules.validLength(text) {
+		logger.Info("reply-length violates the comment-rules")
+		response.Status = fiber.StatusBadRequest
+	} else if text, state, filterResult := filterComment(text, uid); filterResult.Action == filterReject {
+		response.Status = fiber.StatusBadRequest
+		response.Message = "reply was rejected by the filter"
+	} else if err := dbInsert("comments", CommentInsert{
+		Pid:    parents[0].Pid,
+		Uid:    uid,
+		Parent: cid,
+		Text:   text,
+		State:  state,
+	}); err != nil {
+		logger.Sugar().Warnf("Writing reply to database failed with error: %v", err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response = getPostComments(c, parents[0].Pid)
+	}
+
+	return response
+}
+
+func postCommentsReply(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Text string `json:"text"`
+	})
+
+	if cid := c.QueryInt("cid", -1); cid < 0 {
+		logger.Info(`query doesn't include valid "cid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ text string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else {
+		response = addReply(c, cid, body.Text)
+	}
+
+	return response
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type Role string
+
+const (
+	roleOwner     Role = "owner"
+	roleEditor    Role = "editor"
+	roleModerator Role = "moderator"
+	roleFiles     Role = "files"
+	roleMember    Role = "member"
+)
+
+type Permission string
+
+const (
+	// every logged-in user
+	permLogin Permission = "login"
+	// edit posts, their comment-rules and polls
+	permPosts Permission = "posts"
+	// see, answer, moderate and delete all comments
+	permComments Permission = "comments"
+	// manage the users
+	permUsers Permission = "users"
+	// manage the uploaded files
+	permFiles Permission = "files"
+)
+
+// permissions of the roles in addition to "login"
+var rolePermissions = map[Role][]Permission{
+	roleOwner:     {permPosts, permComments, permUsers, permFiles},
+	roleEditor:    {permPosts, permFiles},
+	roleModerator: {permComments},
+	roleFiles:     {permFiles},
+	roleMember:    {},
+}
+
+func validRole(role Role) bool {
+	_, ok := rolePermissions[role]
+
+	return ok
+}
+
+func (role Role) can(permission Permission) bool {
+	if !validRole(role) {
+		return false
+	} else if permission == permLogin {
+		return true
+	} else {
+		return slices.Contains(rolePermissions[role], permission)
+	}
+}
+
+// returns the permissions of the role beyond "login"
+func (role Role) permissions() []Permission {
+	return append([]Permission{}, rolePermissions[role]...)
+}
+
+// roles with any additional permission get access to the admin-interface
+func (role Role) admin() bool {
+	return len(rolePermissions[role]) > 0
+}
+
+// a missing, expired or revoked session, which is answered with "401 Unauthorized"
+var errInvalidSession = fmt.Errorf("invalid session")
+
+// retrieves the role of the user of the session, an invalid session results in errInvalidSession
+func getRole(c *fiber.Ctx) (Role, error) {
+	claims, err := extractClaims(c)
+
+	if err != nil {
+		logger.Sugar().Debugf("invalid session-token: %v", err)
+
+		return "", errInvalidSession
+	}
+
+	// retrieve the user from the database
+	response, err := dbSelect[struct {
+		Role        Role
+		Tid         int
+		TotpEnabled bool `db:"totp_enabled"`
+	}]("users", "uid = ? AND deleted IS NULL LIMIT 1", claims.Uid)
+
+	if err != nil {
+		return "", err
+	} else if len(response) != 1 || response[0].Tid != claims.Tid {
+		return "", errInvalidSession
+	} else if active, err := activeSession(c, claims.Sid, claims.Uid); err != nil {
+		return "", err
+	} else if !active {
+		return "", errInvalidSession
+
+		// administrative roles only take effect after enabling two-factor-authentication, if required
+	} else if Config.TwoFactor.RequireForAdmins && response[0].Role.admin() && !response[0].TotpEnabled {
+		return roleMember, nil
+	} else {
+		return response[0].Role, nil
+	}
+}
+
+// checks wether the user of the session has the permission
+func checkPermission(c *fiber.Ctx, permission Permission) (bool, error) {
+	if role, err := getRole(c); err != nil {
+		return false, err
+	} else {
+		return role.can(permission), nil
+	}
+}
+
+// transfers the ownership to another user, the previous owner becomes an editor
+func postUsersOwner(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if requestUid, _, err := extractJWT(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if role, err := getRole(c); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if role != roleOwner {
+		logger.Sugar().Warn("only the owner can transfer the ownership")
+		response.Status = fiber.StatusForbidden
+	} else if uid := c.QueryInt("uid", -1); uid < 0 {
+		logger.Info(`query doesn't include valid "uid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if uid == requestUid {
+		logger.Info("user is already the owner")
+		response.Status = fiber.StatusBadRequest
+	} else if users, err := dbSelect[struct{ Uid int }]("users", "uid = ? AND deleted IS NULL", uid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(users) != 1 {
+		logger.Sugar().Infof("user %d doesn't exist", uid)
+		response.Status = fiber.StatusBadRequest
+	} else if tx, err := db.Begin(); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("UPDATE users SET role = ? WHERE uid = ?", roleEditor, requestUid); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else if _, err := tx.Exec("UPDATE users SET role = ? WHERE uid = ?", roleOwner, uid); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else if err := tx.Commit(); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			logger.Sugar().Infof("ownership transferred from user %d to user %d", requestUid, uid)
+
+			// the previous owner can't manage the users anymore
+			response = getAccount(c)
+		}
+	}
+
+	return response
+}
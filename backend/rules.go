@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// per-post overrides of the comment-rules, nil-values use the global rules
+type PostRules struct {
+	Pid        int     `json:"-"`
+	OpenDays   *int    `db:"open_days" json:"open_days"`
+	AllowPast  *bool   `db:"allow_past" json:"allow_past"`
+	MaxPerUser *int    `db:"max_per_user" json:"max_per_user"`
+	MinLength  *int    `db:"min_length" json:"min_length"`
+	MaxLength  *int    `db:"max_length" json:"max_length"`
+	Visibility *string `json:"visibility"`
+}
+
+func (overrides PostRules) apply(rules CommentRules) CommentRules {
+	if overrides.OpenDays != nil {
+		rules.OpenDays = *overrides.OpenDays
+	}
+	if overrides.AllowPast != nil {
+		rules.AllowPast = *overrides.AllowPast
+	}
+	if overrides.MaxPerUser != nil {
+		rules.MaxPerUser = *overrides.MaxPerUser
+	}
+	if overrides.MinLength != nil {
+		rules.MinLength = *overrides.MinLength
+	}
+	if overrides.MaxLength != nil {
+		rules.MaxLength = *overrides.MaxLength
+	}
+	if overrides.Visibility != nil {
+		rules.Visibility = *overrides.Visibility
+	}
+
+	return rules
+}
+
+// checks for values, that would make a door uncommentable
+func (rules CommentRules) validate() error {
+	if rules.OpenDays < 0 {
+		return fmt.Errorf("open_days must not be negative")
+	} else if rules.MaxPerUser < 0 {
+		return fmt.Errorf("max_per_user must not be negative")
+	} else if rules.MinLength < 0 {
+		return fmt.Errorf("min_length must not be negative")
+	} else if rules.MaxLength < 0 {
+		return fmt.Errorf("max_length must not be negative")
+	} else if rules.MaxLength > 0 && rules.MinLength > rules.MaxLength {
+		return fmt.Errorf("min_length must not be greater than max_length")
+	} else {
+		return nil
+	}
+}
+
+// retrieves the effective comment-rules of a post and its overrides
+func getCommentRules(pid int) (CommentRules, PostRules, error) {
+	overrides := PostRules{Pid: pid}
+
+	if rules, err := dbSelect[PostRules]("rules", "pid = ? LIMIT 1", pid); err != nil {
+		return CommentRules{}, overrides, err
+	} else if len(rules) == 1 {
+		overrides = rules[0]
+	}
+
+	return overrides.apply(Config.Comments.CommentRules), overrides, nil
+}
+
+// returns the first day on which a door doesn't accept comments anymore
+func (rules CommentRules) closingDate(postDate string) (string, error) {
+	if date, err := time.ParseInLocation(time.DateOnly, postDate, time.Local); err != nil {
+		return "", err
+	} else {
+		return date.AddDate(0, 0, rules.OpenDays).Format(time.DateOnly), nil
+	}
+}
+
+// checks wether a door accepts new comments at the given time
+func (rules CommentRules) open(postDate string, now time.Time) (bool, error) {
+	today := now.Format(time.DateOnly)
+
+	if today < postDate {
+		return false, nil
+	} else if rules.AllowPast {
+		return true, nil
+	} else if closingDate, err := rules.closingDate(postDate); err != nil {
+		return false, err
+	} else {
+		return today < closingDate, nil
+	}
+}
+
+// comment-visibilities
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+	visibilityReveal  = "reveal"
+)
+
+func validVisibility(visibility string) bool {
+	return visibility == visibilityPublic || visibility == visibilityPrivate || visibility == visibilityReveal
+}
+
+// removes the comments a user isn't allowed to see: for private posts (or revealed ones before
+// the door closes) users only see the threads of their own comments
+func (rules CommentRules) visibleComments(comments []Comment, uid int, postDate string, now time.Time) ([]Comment, error) {
+	switch rules.Visibility {
+	case visibilityPublic:
+		return comments, nil
+	case visibilityReveal:
+		if closingDate, err := rules.closingDate(postDate); err != nil {
+			return nil, err
+		} else if now.Format(time.DateOnly) >= closingDate {
+			return comments, nil
+		}
+	}
+
+	parents := map[int]int{}
+	authors := map[int]int{}
+
+	for _, comment := range comments {
+		parents[comment.Cid] = comment.Parent
+		authors[comment.Cid] = comment.Uid
+	}
+
+	visible := []Comment{}
+
+	for _, comment := range comments {
+		// find the top-level comment of the thread
+		root := comment.Cid
+
+		for depth := 0; parents[root] != 0 && depth <= len(comments); depth++ {
+			root = parents[root]
+		}
+
+		if authors[root] == uid {
+			visible = append(visible, comment)
+		}
+	}
+
+	return visible, nil
+}
+
+// checks wether the thread of a comment is visible to the user, with the same rules as the comment-list
+func threadVisible(comment Comment, uid int) (bool, error) {
+	root := comment
+
+	for depth := 0; root.Parent != 0 && depth <= Config.Comments.MaxDepth; depth++ {
+		if parents, err := dbSelect[Comment]("comments", "cid = ? LIMIT 1", root.Parent); err != nil {
+			return false, err
+		} else if len(parents) != 1 {
+			return false, nil
+		} else {
+			root = parents[0]
+		}
+	}
+
+	if postDate, err := getPostDate(root.Pid); err != nil {
+		return false, err
+	} else if rules, _, err := getCommentRules(root.Pid); err != nil {
+		return false, err
+	} else if visible, err := rules.visibleComments([]Comment{root}, uid, postDate, time.Now()); err != nil {
+		return false, err
+	} else {
+		return len(visible) == 1, nil
+	}
+}
+
+func (rules CommentRules) validLength(text string) bool {
+	length := utf8.RuneCountInString(strings.TrimSpace(text))
+
+	return length > 0 && length >= rules.MinLength && (rules.MaxLength <= 0 || length <= rules.MaxLength)
+}
+
+func getPostsRules(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if pid := c.QueryInt("pid", -1); pid < 0 {
+		logger.Info(`query doesn't include valid "pid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if rules, overrides, err := getCommentRules(pid); err != nil {
+		logger.Sugar().Errorf("can't retrieve comment-rules: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = struct {
+			Rules     CommentRules `json:"rules"`
+			Overrides PostRules    `json:"overrides"`
+		}{
+			Rules:     rules,
+			Overrides: overrides,
+		}
+	}
+
+	return response
+}
+
+func patchPostsRules(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permPosts); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		logger.Sugar().Warn("user lacks the permission")
+		response.Status = fiber.StatusForbidden
+	} else {
+		body := new(PostRules)
+
+		if pid := c.QueryInt("pid", -1); pid < 0 {
+			logger.Info(`query doesn't include valid "pid"`)
+			response.Status = fiber.StatusBadRequest
+		} else if err := c.BodyParser(body); err != nil {
+			logger.Info(err.Error())
+			response.Status = fiber.StatusBadRequest
+		} else if body.Visibility != nil && !validVisibility(*body.Visibility) {
+			logger.Sugar().Infof("invalid visibility %q", *body.Visibility)
+			response.Status = fiber.StatusBadRequest
+
+			// the overrides are checked together with the global rules they are combined with
+		} else if err := body.apply(Config.Comments.CommentRules).validate(); err != nil {
+			logger.Sugar().Infof("invalid comment-rules: %v", err)
+			response.Status = fiber.StatusBadRequest
+			response.Message = err.Error()
+		} else if postDate, err := getPostDate(pid); err != nil {
+			response.Status = fiber.StatusInternalServerError
+		} else if postDate == "" {
+			response.Status = fiber.StatusBadRequest
+		} else if _, err := db.Exec("REPLACE INTO rules (pid, open_days, allow_past, max_per_user, min_length, max_length, visibility) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			pid, body.OpenDays, body.AllowPast, body.MaxPerUser, body.MinLength, body.MaxLength, body.Visibility); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			response = getPostsRules(c)
+		}
+	}
+
+	return response
+}
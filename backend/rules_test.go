@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCommentRulesValidate(t *testing.T) {
+	valid := CommentRules{OpenDays: 1, MaxPerUser: 1, MinLength: 1, MaxLength: 500}
+
+	tests := []struct {
+		name   string
+		modify func(rules *CommentRules)
+		valid  bool
+	}{
+		{"defaults", func(rules *CommentRules) {}, true},
+		{"unlimited", func(rules *CommentRules) { rules.MaxPerUser = 0; rules.MaxLength = 0; rules.MinLength = 1000 }, true},
+		{"min equals max", func(rules *CommentRules) { rules.MinLength = 500 }, true},
+		{"negative open_days", func(rules *CommentRules) { rules.OpenDays = -1 }, false},
+		{"negative max_per_user", func(rules *CommentRules) { rules.MaxPerUser = -1 }, false},
+		{"negative min_length", func(rules *CommentRules) { rules.MinLength = -1 }, false},
+		{"negative max_length", func(rules *CommentRules) { rules.MaxLength = -1 }, false},
+		{"min above max", func(rules *CommentRules) { rules.MinLength = 501 }, false},
+	}
+
+	for _, test := range tests {
+		rules := valid
+		test.modify(&rules)
+
+		if err := rules.validate(); (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestPostRulesValidateWithGlobalRules(t *testing.T) {
+	global := CommentRules{OpenDays: 1, MaxPerUser: 1, MinLength: 1, MaxLength: 500}
+	minLength := 600
+	maxLength := 1000
+
+	// the override is only invalid in combination with the global maximum
+	if err := (PostRules{MinLength: &minLength}).apply(global).validate(); err == nil {
+		t.Error("minimum above the global maximum was accepted")
+	} else if err := (PostRules{MinLength: &minLength, MaxLength: &maxLength}).apply(global).validate(); err != nil {
+		t.Errorf("minimum with a larger maximum was rejected: %v", err)
+	}
+}
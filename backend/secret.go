@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+)
+
+// wraps sensitive values (password-hashes, secrets, ...) so they are stored in the
+// database but never serialized to JSON or printed to the log
+type Secret[T any] struct {
+	value T
+}
+
+func newSecret[T any](value T) Secret[T] {
+	return Secret[T]{value: value}
+}
+
+// returns the wrapped value
+func (secret Secret[T]) Reveal() T {
+	return secret.value
+}
+
+func (secret *Secret[T]) Scan(src any) error {
+	var value sql.Null[T]
+
+	if err := value.Scan(src); err != nil {
+		return err
+	}
+
+	secret.value = value.V
+
+	return nil
+}
+
+func (secret Secret[T]) Value() (driver.Value, error) {
+	return sql.Null[T]{V: secret.value, Valid: true}.Value()
+}
+
+func (secret Secret[T]) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+func (secret Secret[T]) String() string {
+	return "[secret]"
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// minimum interval between updates of the "last seen"-time of a session
+const sessionSeenInterval = time.Minute
+
+// time during which the previous refresh-token is still accepted, so parallel requests don't trigger the reuse-detection
+const refreshGrace = 10 * time.Second
+
+type Session struct {
+	Sid     int        `json:"sid"`
+	Uid     int        `json:"-"`
+	Device  string     `json:"device"`
+	Ip      string     `json:"ip"`
+	Agent   string     `json:"agent"`
+	Created time.Time  `json:"created"`
+	Seen    time.Time  `json:"seen"`
+	Revoked *time.Time `json:"-"`
+	// wether this is the session of the request
+	Current bool `db:"-" json:"current"`
+}
+
+// derives a readable device-name like "Firefox on Linux" from the user-agent
+func deviceName(agent string) string {
+	find := func(candidates [][2]string) string {
+		for _, candidate := range candidates {
+			if strings.Contains(agent, candidate[0]) {
+				return candidate[1]
+			}
+		}
+
+		return ""
+	}
+
+	// the order matters, since user-agents contain the names of other browsers and systems as well
+	browser := find([][2]string{{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"}})
+	system := find([][2]string{{"Android", "Android"}, {"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"Linux", "Linux"}})
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "unknown device"
+	}
+}
+
+// only the hash of the refresh-tokens is stored in the database
+func hashRefreshToken(secret string) []byte {
+	hash := sha256.Sum256([]byte(secret))
+
+	return hash[:]
+}
+
+func generateRefreshSecret() (string, error) {
+	random := make([]byte, 32)
+
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// splits a refresh-token of the form "<sid>.<secret>"
+func parseRefreshToken(token string) (int, string, bool) {
+	if sidString, secret, ok := strings.Cut(token, "."); !ok || secret == "" {
+		return 0, "", false
+	} else if sid, err := strconv.Atoi(sidString); err != nil {
+		return 0, "", false
+	} else {
+		return sid, secret, true
+	}
+}
+
+// stores a new session for the request and sets its refresh-cookie
+func createSession(c *fiber.Ctx, uid int) (int, error) {
+	agent := c.Get(fiber.HeaderUserAgent)
+
+	if secret, err := generateRefreshSecret(); err != nil {
+		return 0, err
+	} else if res, err := db.Exec("INSERT INTO sessions (uid, device, ip, agent, refresh) VALUES (?, ?, ?, ?, ?)", uid, deviceName(agent), c.IP(), agent, hashRefreshToken(secret)); err != nil {
+		return 0, err
+	} else if sid, err := res.LastInsertId(); err != nil {
+		return 0, err
+	} else {
+		setRefreshCookie(c, int(sid), secret, time.Now())
+
+		return int(sid), nil
+	}
+}
+
+// stores the refresh-token in a cookie, which expires with the session
+func setRefreshCookie(c *fiber.Ctx, sid int, secret string, created time.Time) {
+	maxAge := Config.SessionExpire
+
+	if remaining := time.Until(created.Add(Config.MaxLifetime)); remaining < maxAge {
+		maxAge = remaining
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "refresh",
+		Value:    strconv.Itoa(sid) + "." + secret,
+		HTTPOnly: true,
+		SameSite: "strict",
+		MaxAge:   int(maxAge.Seconds()),
+	})
+}
+
+// rotates the refresh-token and issues a new access-token, returns the http-status
+func refreshSession(c *fiber.Ctx) int {
+	sid, secret, ok := parseRefreshToken(c.Cookies("refresh"))
+
+	if !ok {
+		return fiber.StatusUnauthorized
+	}
+
+	sessions, err := dbSelect[struct {
+		Uid       int
+		Refresh   []byte
+		Previous  []byte
+		Created   time.Time
+		Refreshed time.Time
+	}]("sessions", "sid = ? AND revoked IS NULL LIMIT 1", sid)
+
+	if err != nil {
+		return fiber.StatusInternalServerError
+	} else if len(sessions) != 1 {
+		removeSessionCookie(c)
+
+		return fiber.StatusUnauthorized
+	}
+
+	session := sessions[0]
+	now := time.Now()
+	hash := hashRefreshToken(secret)
+
+	if now.Sub(session.Created) > Config.MaxLifetime || now.Sub(session.Refreshed) > Config.SessionExpire {
+		logger.Sugar().Debugf("session %d expired", sid)
+		removeSessionCookie(c)
+
+		return fiber.StatusUnauthorized
+	}
+
+	if subtle.ConstantTimeCompare(hash, session.Refresh) == 1 {
+		newSecret, err := generateRefreshSecret()
+		if err != nil {
+			logger.Sugar().Error(err.Error())
+
+			return fiber.StatusInternalServerError
+		}
+
+		// only rotate, if no parallel request did it already
+		if res, err := db.Exec("UPDATE sessions SET refresh = ?, previous = ?, refreshed = ? WHERE sid = ? AND refresh = ?", hashRefreshToken(newSecret), hash, now, sid, hash); err != nil {
+			logger.Sugar().Error(err.Error())
+
+			return fiber.StatusInternalServerError
+		} else if count, err := res.RowsAffected(); err != nil {
+			logger.Sugar().Error(err.Error())
+
+			return fiber.StatusInternalServerError
+		} else if count == 1 {
+			setRefreshCookie(c, sid, newSecret, session.Created)
+		}
+
+		// a parallel request of the same client already rotated the token
+	} else if subtle.ConstantTimeCompare(hash, session.Previous) == 1 && now.Sub(session.Refreshed) < refreshGrace {
+		logger.Sugar().Debugf("session %d was refreshed in parallel", sid)
+
+		// an old refresh-token was used, it might be stolen
+	} else {
+		logger.Sugar().Warnf("refresh-token of session %d was reused from %s, revoking the session", sid, c.IP())
+
+		if err := revokeSession(sid); err != nil {
+			logger.Sugar().Error(err.Error())
+		}
+
+		removeSessionCookie(c)
+
+		return fiber.StatusUnauthorized
+	}
+
+	if tid, err := getTokenId(session.Uid); err != nil {
+		return fiber.StatusUnauthorized
+	} else if err := setSessionCookie(c, session.Uid, tid, sid); err != nil {
+		logger.Sugar().Errorf("failed creating json-webtoken: %v", err.Error())
+
+		return fiber.StatusInternalServerError
+	}
+
+	return fiber.StatusOK
+}
+
+// refreshes the session, if the access-token is expired but there is a refresh-token
+func refreshExpiredSession(c *fiber.Ctx) {
+	if _, err := extractClaims(c); err != nil && c.Cookies("refresh") != "" {
+		if status := refreshSession(c); status != fiber.StatusOK {
+			logger.Sugar().Debugf("can't refresh session: HTTP %d", status)
+		}
+	}
+}
+
+// public endpoint to renew the access-token
+func handleRefresh(c *fiber.Ctx) error {
+	return responseMessage{
+		Status: refreshSession(c),
+	}.send(c)
+}
+
+// checks wether the session exists and isn't revoked and updates its "last seen"-time
+func activeSession(c *fiber.Ctx, sid, uid int) (bool, error) {
+	if sessions, err := dbSelect[struct{ Seen time.Time }]("sessions", "sid = ? AND uid = ? AND revoked IS NULL AND created > ? LIMIT 1", sid, uid, time.Now().Add(-Config.MaxLifetime)); err != nil {
+		return false, err
+	} else if len(sessions) != 1 {
+		return false, nil
+	} else if now := time.Now(); now.Sub(sessions[0].Seen) > sessionSeenInterval {
+		if _, err := db.Exec("UPDATE sessions SET seen = ?, ip = ? WHERE sid = ?", now, c.IP(), sid); err != nil {
+			logger.Sugar().Warnf("can't update session %d: %v", sid, err)
+		}
+	}
+
+	return true, nil
+}
+
+// revokes a session, so its token isn't accepted anymore
+func revokeSession(sid int) error {
+	_, err := db.Exec("UPDATE sessions SET revoked = ? WHERE sid = ? AND revoked IS NULL", time.Now(), sid)
+
+	return err
+}
+
+// revokes all sessions of a user, except the given one
+func revokeUserSessions(uid, exceptSid int) error {
+	_, err := db.Exec("UPDATE sessions SET revoked = ? WHERE uid = ? AND sid != ? AND revoked IS NULL", time.Now(), uid, exceptSid)
+
+	return err
+}
+
+func getAccountSessions(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if claims, err := extractClaims(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+
+		// sessions are only valid as long as their refresh-token
+	} else if sessions, err := dbSelect[Session]("sessions", "uid = ? AND revoked IS NULL AND created > ? AND refreshed > ? ORDER BY seen DESC", claims.Uid, time.Now().Add(-Config.MaxLifetime), time.Now().Add(-Config.SessionExpire)); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		for ii := range sessions {
+			sessions[ii].Current = sessions[ii].Sid == claims.Sid
+		}
+
+		response.Data = sessions
+	}
+
+	return response
+}
+
+// revokes one of the own sessions
+func deleteAccountSessions(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if claims, err := extractClaims(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if sid := c.QueryInt("sid", -1); sid < 0 {
+		logger.Info(`query doesn't include valid "sid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if _, err := db.Exec("UPDATE sessions SET revoked = ? WHERE sid = ? AND uid = ? AND revoked IS NULL", time.Now(), sid, claims.Uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if sid == claims.Sid {
+		removeSessionCookie(c)
+
+		response.Status = fiber.StatusOK
+	} else {
+		response = getAccountSessions(c)
+	}
+
+	return response
+}
+
+// logs out a user on all devices
+func deleteUsersSessions(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if uid := c.QueryInt("uid", -1); uid < 0 {
+		logger.Info(`query doesn't include valid "uid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if err := incTokenId(uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		logger.Sugar().Infof("user %d was logged out on all devices", uid)
+
+		response = getUsers(c)
+	}
+
+	return response
+}
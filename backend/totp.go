@@ -0,0 +1,437 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// parameters of the time-based one-time-passwords (RFC 6238)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// accepted time-steps before and after the current one
+	totpSkew = 1
+)
+
+const recoveryCodeCount = 10
+
+// time during which the second login-step has to be completed
+const twoFactorLoginExpire = 5 * time.Minute
+
+const pendingLoginCookie = "login_pending"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// creates a new random totp-secret
+func createTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// calculates the one-time-password for a time-step (RFC 4226)
+func totpCode(secret []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	code := value % 1_000_000
+
+	return fmt.Sprintf("%0*d", totpDigits, code)
+}
+
+// checks a code against the secret and returns the matched time-step; time-steps
+// up to "lastCounter" were already used and are rejected
+func verifyTotp(encodedSecret, code string, now time.Time, lastCounter int64) (int64, bool) {
+	secret, err := totpEncoding.DecodeString(encodedSecret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter > lastCounter && subtle.ConstantTimeCompare([]byte(totpCode(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// builds the "otpauth://"-uri for the authenticator-apps
+func totpProvisioningUri(name, secret string) string {
+	label := url.PathEscape(Config.TwoFactor.Issuer + ":" + name)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", Config.TwoFactor.Issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+}
+
+// verifies a totp-code of the user and marks it as used
+func useTotpCode(user User, code string) (bool, error) {
+	if user.TotpSecret.Reveal() == "" {
+		return false, nil
+	} else if counter, ok := verifyTotp(user.TotpSecret.Reveal(), strings.TrimSpace(code), time.Now(), user.TotpCounter); !ok {
+		return false, nil
+
+		// only accept the code if no other request used it in the meantime
+	} else if res, err := db.Exec("UPDATE users SET totp_counter = ? WHERE uid = ? AND totp_counter < ?", counter, user.Uid, counter); err != nil {
+		return false, err
+	} else if count, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else {
+		return count == 1, nil
+	}
+}
+
+// creates new recovery-codes for the user and replaces the old ones
+func createRecoveryCodes(uid int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery WHERE uid = ?", uid); err != nil {
+		return nil, err
+	}
+
+	for ii := range codes {
+		random := make([]byte, 5)
+
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(random))
+		codes[ii] = code[:4] + "-" + code[4:]
+
+		if hash, err := bcrypt.GenerateFromPassword([]byte(codes[ii]), bcrypt.DefaultCost); err != nil {
+			return nil, err
+		} else if _, err := tx.Exec("INSERT INTO recovery (uid, code) VALUES (?, ?)", uid, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// checks a recovery-code and deletes it, so it can only be used once
+func useRecoveryCode(uid int, code string) (bool, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	if hashes, err := dbSelect[struct{ Code []byte }]("recovery", "uid = ?", uid); err != nil {
+		return false, err
+	} else {
+		for _, hash := range hashes {
+			if bcrypt.CompareHashAndPassword(hash.Code, []byte(code)) == nil {
+				if res, err := db.Exec("DELETE FROM recovery WHERE uid = ? AND code = ?", uid, hash.Code); err != nil {
+					return false, err
+				} else if count, err := res.RowsAffected(); err != nil {
+					return false, err
+				} else {
+					return count > 0, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// stores a short-living token for the second login-step
+func startTwoFactorLogin(c *fiber.Ctx, user User) responseMessage {
+	var response responseMessage
+
+	if jwt, err := Config.signJWTExpiring(JWTPayload{
+		Uid:   user.Uid,
+		Tid:   user.Tid,
+		Stage: "2fa",
+	}, twoFactorLoginExpire); err != nil {
+		logger.Sugar().Errorf("failed creating json-webtoken: %v", err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		c.Cookie(&fiber.Cookie{
+			Name:     pendingLoginCookie,
+			Value:    jwt,
+			HTTPOnly: true,
+			SameSite: "strict",
+			MaxAge:   int(twoFactorLoginExpire.Seconds()),
+		})
+
+		response.Data = LoginInfo{
+			LoggedIn:  false,
+			TwoFactor: true,
+		}
+	}
+
+	return response
+}
+
+// retrieves the user of the pending login
+func pendingLoginUser(c *fiber.Ctx) (User, bool, error) {
+	var claims JWT
+
+	if token, err := parseJWT(c.Cookies(pendingLoginCookie), &claims); err != nil || !token.Valid || claims.CustomClaims.Stage != "2fa" {
+		return User{}, false, nil
+	} else if users, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL LIMIT 1", claims.CustomClaims.Uid); err != nil {
+		return User{}, false, err
+	} else if len(users) != 1 || users[0].Tid != claims.CustomClaims.Tid || !users[0].TotpEnabled {
+		return User{}, false, nil
+	} else {
+		return users[0], true, nil
+	}
+}
+
+func handleLoginTwoFactor(c *fiber.Ctx) error {
+	var response responseMessage
+
+	body := new(struct {
+		Code     string `json:"code"`
+		Recovery string `json:"recovery"`
+	})
+
+	if err := c.BodyParser(body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ code string; recovery string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if user, ok, err := pendingLoginUser(c); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !ok {
+		logger.Info("no pending login")
+		response.Status = fiber.StatusUnauthorized
+	} else if !checkLoginAllowed(c, user.Name) {
+		response.Status = fiber.StatusTooManyRequests
+		response.Message = "Too many failed logins"
+	} else {
+		var valid bool
+
+		if body.Recovery != "" {
+			valid, err = useRecoveryCode(user.Uid, body.Recovery)
+		} else {
+			valid, err = useTotpCode(user, body.Code)
+		}
+
+		if err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else if !valid {
+			logger.Sugar().Infof("second login-step of user %d failed", user.Uid)
+			limiter.fail(c.IP(), user.Name, time.Now())
+
+			response.Status = fiber.StatusUnauthorized
+			response.Message = "Wrong code"
+		} else {
+			if body.Recovery != "" {
+				logger.Sugar().Infof("user %d logged in with a recovery-code", user.Uid)
+			}
+
+			c.ClearCookie(pendingLoginCookie)
+
+			response = completeLogin(c, user)
+		}
+	}
+
+	return response.send(c)
+}
+
+// retrieves the user of the session
+func sessionUser(c *fiber.Ctx) (User, error) {
+	if uid, _, err := extractJWT(c); err != nil {
+		return User{}, err
+	} else if users, err := dbSelect[User]("users", "uid = ? AND deleted IS NULL LIMIT 1", uid); err != nil {
+		return User{}, err
+	} else if len(users) != 1 {
+		return User{}, fmt.Errorf("user %d doesn't exist", uid)
+	} else {
+		return users[0], nil
+	}
+}
+
+// creates a new totp-secret, that has to be confirmed before it is enabled
+func postAccount2fa(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if user, err := sessionUser(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if user.TotpEnabled {
+		logger.Sugar().Infof("user %d already has two-factor-authentication enabled", user.Uid)
+		response.Status = fiber.StatusConflict
+	} else if secret, err := createTotpSecret(); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if _, err := db.Exec("UPDATE users SET totp_secret = ?, totp_counter = 0 WHERE uid = ?", secret, user.Uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		uri := totpProvisioningUri(user.Name, secret)
+
+		if png, err := qrcode.Encode(uri, qrcode.Medium, 256); err != nil {
+			logger.Sugar().Errorf("can't create qr-code: %v", err)
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			response.Data = struct {
+				Secret string `json:"secret"`
+				Uri    string `json:"uri"`
+				// base64-encoded png for scanning with the authenticator-app
+				Qr string `json:"qr"`
+			}{
+				Secret: secret,
+				Uri:    uri,
+				Qr:     base64.StdEncoding.EncodeToString(png),
+			}
+		}
+	}
+
+	return response
+}
+
+// enables the two-factor-authentication after confirming a code and sends the recovery-codes
+func postAccount2faEnable(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Code string `json:"code"`
+	})
+
+	if user, err := sessionUser(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ code string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if user.TotpEnabled {
+		response.Status = fiber.StatusConflict
+	} else if user.TotpSecret.Reveal() == "" {
+		logger.Info("two-factor-authentication wasn't set up")
+		response.Status = fiber.StatusBadRequest
+	} else if valid, err := useTotpCode(user, body.Code); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if !valid {
+		response.Status = fiber.StatusForbidden
+		response.Message = "Wrong code"
+	} else if _, err := db.Exec("UPDATE users SET totp_enabled = TRUE WHERE uid = ?", user.Uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if codes, err := createRecoveryCodes(user.Uid); err != nil {
+		logger.Sugar().Errorf("can't create recovery-codes: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		logger.Sugar().Infof("user %d enabled two-factor-authentication", user.Uid)
+
+		response.Data = codes
+	}
+
+	return response
+}
+
+// disables the two-factor-authentication, requires the password and a code
+func postAccount2faDisable(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	})
+
+	if user, err := sessionUser(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ password string; code string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if !user.TotpEnabled {
+		response.Status = fiber.StatusBadRequest
+
+		// administrative roles can't disable it, if it is required
+	} else if Config.TwoFactor.RequireForAdmins && user.Role.admin() {
+		logger.Sugar().Infof("user %d can't disable the required two-factor-authentication", user.Uid)
+		response.Status = fiber.StatusForbidden
+	} else if bcrypt.CompareHashAndPassword(user.Password.Reveal(), []byte(body.Password)) != nil {
+		response.Status = fiber.StatusForbidden
+		response.Message = "wrong password"
+	} else if valid, err := useTotpCode(user, body.Code); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if !valid {
+		response.Status = fiber.StatusForbidden
+		response.Message = "Wrong code"
+	} else if _, err := db.Exec("UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_counter = 0 WHERE uid = ?", user.Uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if _, err := db.Exec("DELETE FROM recovery WHERE uid = ?", user.Uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		logger.Sugar().Infof("user %d disabled two-factor-authentication", user.Uid)
+
+		response = getAccount(c)
+	}
+
+	return response
+}
+
+// replaces the recovery-codes of the user
+func postAccount2faRecovery(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	body := new(struct {
+		Code string `json:"code"`
+	})
+
+	if user, err := sessionUser(c); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusBadRequest
+	} else if err := c.BodyParser(&body); err != nil {
+		logger.Sugar().Warn(`"body" can't be parsed as "{ code string }"`)
+		response.Status = fiber.StatusBadRequest
+	} else if !user.TotpEnabled {
+		response.Status = fiber.StatusBadRequest
+	} else if valid, err := useTotpCode(user, body.Code); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else if !valid {
+		response.Status = fiber.StatusForbidden
+		response.Message = "Wrong code"
+	} else if codes, err := createRecoveryCodes(user.Uid); err != nil {
+		logger.Sugar().Errorf("can't create recovery-codes: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = codes
+	}
+
+	return response
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// shared secret of the SHA1-test-vectors in RFC 6238, appendix B
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTotpCodeRfc6238(t *testing.T) {
+	// the RFC lists 8-digit codes, the 6-digit codes are their last digits
+	vectors := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, vector := range vectors {
+		if code := totpCode(rfc6238Secret, vector.time/totpPeriod); code != vector.code {
+			t.Errorf("time %d: got code %q, want %q", vector.time, code, vector.code)
+		}
+	}
+}
+
+func TestVerifyTotpWindow(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		offset int64
+		valid  bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps before", -2, false},
+		{"two steps after", 2, false},
+	}
+
+	for _, test := range tests {
+		code := totpCode(rfc6238Secret, current+test.offset)
+
+		if counter, ok := verifyTotp(secret, code, now, 0); ok != test.valid {
+			t.Errorf("%s: got valid %v, want %v", test.name, ok, test.valid)
+		} else if ok && counter != current+test.offset {
+			t.Errorf("%s: got counter %d, want %d", test.name, counter, current+test.offset)
+		}
+	}
+}
+
+func TestVerifyTotpRejectsUsedCounter(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111109, 0)
+	current := now.Unix() / totpPeriod
+	code := totpCode(rfc6238Secret, current)
+
+	counter, ok := verifyTotp(secret, code, now, 0)
+	if !ok {
+		t.Fatal("valid code was rejected")
+	}
+
+	// the same code can't be used twice
+	if _, ok := verifyTotp(secret, code, now, counter); ok {
+		t.Error("code of an already used time-step was accepted")
+	}
+
+	// neither can an older code within the window after a newer one was used
+	if _, ok := verifyTotp(secret, totpCode(rfc6238Secret, current-1), now, counter); ok {
+		t.Error("code of a time-step before the used one was accepted")
+	}
+
+	// but the following time-step is still accepted
+	if next, ok := verifyTotp(secret, totpCode(rfc6238Secret, current+1), now, counter); !ok || next != current+1 {
+		t.Errorf("code of the next time-step: got counter %d and valid %v", next, ok)
+	}
+}
+
+func TestVerifyTotpInvalidInput(t *testing.T) {
+	secret := totpEncoding.EncodeToString(rfc6238Secret)
+	now := time.Unix(1111111109, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"wrong code", secret, "000000"},
+		{"short code", secret, "08180"},
+		{"long code", secret, "0818040"},
+		{"invalid secret", "not base32!", totpCode(rfc6238Secret, now.Unix()/totpPeriod)},
+	}
+
+	for _, test := range tests {
+		if _, ok := verifyTotp(test.secret, test.code, now, 0); ok {
+			t.Errorf("%s: was accepted", test.name)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// interval in which the trash is checked for expired entries
+const trashPurgeInterval = time.Hour
+
+// moves rows into the trash by setting their "deleted"-timestamp
+func softDelete(table, column string, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []any{time.Now()}
+
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	_, err := db.Exec(fmt.Sprintf("UPDATE %s SET deleted = ? WHERE %s IN (%s) AND deleted IS NULL", table, column, placeholders), args...)
+
+	return err
+}
+
+func getTrashComments(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permComments); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+	} else if comments, err := dbSelect[Comment]("comments", "deleted IS NOT NULL ORDER BY deleted DESC"); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = comments
+	}
+
+	return response
+}
+
+func getTrashUsers(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permUsers); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+	} else if users, err := dbSelect[User]("users", "deleted IS NOT NULL ORDER BY deleted DESC"); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response.Data = userInfos(users)
+	}
+
+	return response
+}
+
+func postCommentsRestore(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permComments); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+	} else if cid := c.QueryInt("cid", -1); cid < 0 {
+		logger.Info(`query doesn't include valid "cid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if comments, err := dbSelect[Comment]("comments", "cid = ? AND deleted IS NOT NULL LIMIT 1", cid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(comments) != 1 {
+		logger.Sugar().Infof("comment %d isn't in the trash", cid)
+		response.Status = fiber.StatusBadRequest
+	} else if parents, err := dbSelect[struct{ Cid int }]("comments", "cid = ? AND deleted IS NULL", comments[0].Parent); err != nil {
+		response.Status = fiber.StatusInternalServerError
+
+		// replies can only be restored if their parent exists
+	} else if comments[0].Parent != 0 && len(parents) != 1 {
+		logger.Sugar().Infof("parent of comment %d is deleted", cid)
+		response.Status = fiber.StatusConflict
+	} else if thread, err := getCommentThread(cid); err != nil {
+		logger.Sugar().Errorf("can't retrieve replies of comment: %v", err)
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		// restore the replies, that were deleted together with the comment
+		args := []any{comments[0].Deleted}
+
+		for _, threadCid := range thread {
+			args = append(args, threadCid)
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(thread)), ", ")
+
+		if _, err := db.Exec(fmt.Sprintf("UPDATE comments SET deleted = NULL WHERE deleted = ? AND cid IN (%s)", placeholders), args...); err != nil {
+			logger.Sugar().Error(err.Error())
+			response.Status = fiber.StatusInternalServerError
+		} else {
+			response = getTrashComments(c)
+		}
+	}
+
+	return response
+}
+
+func postUsersRestore(c *fiber.Ctx) responseMessage {
+	var response responseMessage
+
+	if allowed, err := checkPermission(c, permUsers); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if !allowed {
+		response.Status = fiber.StatusForbidden
+	} else if uid := c.QueryInt("uid", -1); uid < 0 {
+		logger.Info(`query doesn't include valid "uid"`)
+		response.Status = fiber.StatusBadRequest
+	} else if users, err := dbSelect[struct{ Uid int }]("users", "uid = ? AND deleted IS NOT NULL", uid); err != nil {
+		response.Status = fiber.StatusInternalServerError
+	} else if len(users) != 1 {
+		logger.Sugar().Infof("user %d isn't in the trash", uid)
+		response.Status = fiber.StatusBadRequest
+	} else if _, err := db.Exec("UPDATE users SET deleted = NULL WHERE uid = ?", uid); err != nil {
+		logger.Sugar().Error(err.Error())
+		response.Status = fiber.StatusInternalServerError
+	} else {
+		response = getTrashUsers(c)
+	}
+
+	return response
+}
+
+// permanently deletes everything that is longer in the trash than the retention-period
+func purgeTrash() error {
+	before := time.Now().Add(-Config.TrashRetention)
+
+	// delete the data belonging to the expired comments and users first
+	commands := []string{
+		"DELETE FROM reactions WHERE cid IN (SELECT cid FROM comments WHERE deleted < ?)",
+		"DELETE FROM edits WHERE cid IN (SELECT cid FROM comments WHERE deleted < ?)",
+		"DELETE FROM comments WHERE deleted < ?",
+		"DELETE FROM reactions WHERE cid IN (SELECT cid FROM comments WHERE uid IN (SELECT uid FROM users WHERE deleted < ?))",
+		"DELETE FROM edits WHERE cid IN (SELECT cid FROM comments WHERE uid IN (SELECT uid FROM users WHERE deleted < ?))",
+		"DELETE FROM comments WHERE uid IN (SELECT uid FROM users WHERE deleted < ?)",
+		"DELETE FROM reactions WHERE uid IN (SELECT uid FROM users WHERE deleted < ?)",
+		"DELETE FROM votes WHERE uid IN (SELECT uid FROM users WHERE deleted < ?)",
+		"DELETE FROM layouts WHERE uid IN (SELECT uid FROM users WHERE deleted < ?)",
+		"DELETE FROM recovery WHERE uid IN (SELECT uid FROM users WHERE deleted < ?)",
+		"DELETE FROM sessions WHERE uid IN (SELECT uid FROM users WHERE deleted < ?)",
+		"DELETE FROM magic_links WHERE uid IN (SELECT uid FROM users WHERE deleted < ?)",
+		"DELETE FROM users WHERE deleted < ?",
+	}
+
+	for _, cmd := range commands {
+		if res, err := db.Exec(cmd, before); err != nil {
+			return err
+		} else if count, err := res.RowsAffected(); err == nil && count > 0 {
+			logger.Sugar().Infof("purged %d rows from trash: %q", count, cmd)
+		}
+	}
+
+	return nil
+}
+
+func purgeTrashPeriodically() {
+	for {
+		if err := purgeTrash(); err != nil {
+			logger.Sugar().Errorf("can't purge trash: %v", err)
+		}
+
+		time.Sleep(trashPurgeInterval)
+	}
+}
@@ -11,14 +11,34 @@ export interface Comment {
 	cid: number;
 	pid: number;
 	uid: number;
+	parent: number;
 	text: string;
 	answer?: string;
+	state?: string;
+}
+
+// effective comment-rules of a post
+export interface CommentRules {
+	open_days: number;
+	allow_past: boolean;
+	// maximum number of comments per user, 0 is unlimited
+	max_per_user: number;
+	min_length: number;
+	// 0 is unlimited
+	max_length: number;
+	visibility: string;
 }
 
 export interface User {
 	uid: number;
 	admin: boolean;
 	logged_in: boolean;
+	// the login has to be completed with a code of the second factor
+	two_factor?: boolean;
+	oidc?: boolean;
+	magic_link?: boolean;
+	// replies can be nested up to this depth
+	max_depth?: number;
 }
 
 export interface Login extends User {
@@ -55,7 +55,12 @@ export enum HTTPStatus {
 
 // eslint-disable-next-line @typescript-eslint/naming-convention
 type QueryParams = Record<string, string | { toString(): string }>;
-type APICallResult<T extends object> = { data: T; status: HTTPStatus; ok: boolean };
+type APICallResult<T extends object> = {
+	data: T;
+	status: HTTPStatus;
+	ok: boolean;
+	headers: Headers;
+};
 export async function api_call<K extends object>(
 	method: "GET",
 	api: string,
@@ -122,23 +127,22 @@ export async function api_call<K extends object>(
 	const content_type = response.headers.get("content-type");
 
 	if (content_type && content_type.indexOf("application/json") !== -1) {
-		return { data: (await response.json()) as K, status: response.status, ok: response.ok };
+		return {
+			data: (await response.json()) as K,
+			status: response.status,
+			ok: response.ok,
+			headers: response.headers
+		};
 	} else {
-		return { status: response.status, data: undefined as unknown as K, ok: response.ok };
+		return {
+			status: response.status,
+			data: undefined as unknown as K,
+			ok: response.ok,
+			headers: response.headers
+		};
 	}
 }
 
-export function create_lcg_random(seed: number) {
-	let state = seed;
-
-	function next() {
-		state = (state * 1103515245 + 12345) % 2 ** 32;
-		return state / 2 ** 32;
-	}
-
-	return next;
-}
-
 export const today = new Date();
 
 export function format_date(dt: Date): string {
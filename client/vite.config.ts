@@ -27,6 +27,8 @@ export default defineConfig({
 			input: {
 				index: resolve(__dirname, "src/index.html"),
 				admin: resolve(__dirname, "src/admin.html"),
+				account: resolve(__dirname, "src/account.html"),
+				register: resolve(__dirname, "src/register.html"),
 				About: resolve(__dirname, "src/About.html"),
 				"legal/Impressum": resolve(__dirname, "src/legal/Impressum.html"),
 				"legal/Datenschutz": resolve(__dirname, "src/legal/Datenschutz.html")
@@ -10,6 +10,20 @@ import (
 
 var CONFIG_PATH = "../backend/config.yaml"
 
+// rules for the comments of a post
+type CommentRules struct {
+	// number of days, starting with the opening-day, during which a door accepts comments
+	OpenDays  int  `yaml:"open_days"`
+	AllowPast bool `yaml:"allow_past"`
+	// maximum number of comments per user and door, 0 is unlimited
+	MaxPerUser int `yaml:"max_per_user"`
+	MinLength  int `yaml:"min_length"`
+	// maximum length of a comment, 0 is unlimited
+	MaxLength int `yaml:"max_length"`
+	// who can see the comments: "public", "private" (only the author and admins) or "reveal" (public after the door closes)
+	Visibility string `yaml:"visibility"`
+}
+
 type ConfigYaml struct {
 	LogLevel string `yaml:"log_level"`
 	Database struct {
@@ -21,15 +35,104 @@ type ConfigYaml struct {
 	} `yaml:"database"`
 	ClientSession struct {
 		JwtSignature string `yaml:"jwt_signature"`
-		Expire       string `yaml:"expire"`
+		// file with the signing-keys, created by "rotate-keys"
+		Keyset string `yaml:"keyset"`
+		// algorithm of the keys created by "rotate-keys"
+		Algorithm string `yaml:"algorithm"`
+		// lifetime of the access-tokens, they are renewed with the refresh-token
+		AccessExpire string `yaml:"access_expire"`
+		// sessions end if they aren't refreshed during this time
+		Expire string `yaml:"expire"`
+		// sessions end after this time, even if they are refreshed
+		MaxLifetime string `yaml:"max_lifetime"`
 	} `yaml:"client_session"`
 	Setup struct {
-		Days  int8   `yaml:"days"`
-		Start string `yaml:"start"`
+		Days   int8   `yaml:"days"`
+		Start  string `yaml:"start"`
+		Layout string `yaml:"layout"`
+		// name of the owner-account created by the setup
+		Owner string `yaml:"owner"`
 	} `yaml:"setup"`
+	Comments struct {
+		MaxDepth   int    `yaml:"max_depth"`
+		EditWindow string `yaml:"edit_window"`
+		Moderation bool   `yaml:"moderation"`
+		Filters    struct {
+			Words struct {
+				List   []string `yaml:"list"`
+				Action string   `yaml:"action"`
+			} `yaml:"words"`
+			Links struct {
+				Max    int    `yaml:"max"`
+				Action string `yaml:"action"`
+			} `yaml:"links"`
+			MaxLength int `yaml:"max_length"`
+		} `yaml:"filters"`
+		CommentRules `yaml:",inline"`
+	} `yaml:"comments"`
+	Trash struct {
+		Retention string `yaml:"retention"`
+	} `yaml:"trash"`
+	Invites struct {
+		// default validity of invitation-links
+		Expire string `yaml:"expire"`
+	} `yaml:"invites"`
+	Login struct {
+		// failed attempts after which an account is locked
+		MaxFailures int    `yaml:"max_failures"`
+		Lockout     string `yaml:"lockout"`
+		Backoff     string `yaml:"backoff"`
+		MaxBackoff  string `yaml:"max_backoff"`
+	} `yaml:"login"`
+	TwoFactor struct {
+		// issuer shown in the authenticator-apps
+		Issuer string `yaml:"issuer"`
+		// users with administrative roles have to enable two-factor-authentication
+		RequireForAdmins bool `yaml:"require_for_admins"`
+	} `yaml:"two_factor"`
+	Passwords struct {
+		MinLength     int  `yaml:"min_length"`
+		RequireLetter bool `yaml:"require_letter"`
+		RequireDigit  bool `yaml:"require_digit"`
+	} `yaml:"passwords"`
+	Oidc struct {
+		// login with an external identity-provider
+		Enabled      bool   `yaml:"enabled"`
+		Issuer       string `yaml:"issuer"`
+		ClientId     string `yaml:"client_id"`
+		ClientSecret string `yaml:"client_secret"`
+		// address of the "/api/oidc/callback"-endpoint as registered at the identity-provider
+		RedirectUrl string   `yaml:"redirect_url"`
+		Scopes      []string `yaml:"scopes"`
+		// claim used as name for provisioned users
+		NameClaim string `yaml:"name_claim"`
+		// link users with the same verified email-address on their first login
+		LinkByEmail bool `yaml:"link_by_email"`
+		// unknown users are created with this role, empty disables the provisioning
+		Provision string `yaml:"provision"`
+	} `yaml:"oidc"`
+	MagicLink struct {
+		// login with a link sent by email, requires "smtp"
+		Enabled bool   `yaml:"enabled"`
+		Expire  string `yaml:"expire"`
+	} `yaml:"magic_link"`
+	Smtp struct {
+		Host string `yaml:"host"`
+		Port int    `yaml:"port"`
+		// without user, no authentication is used
+		User     string `yaml:"user"`
+		Password string `yaml:"password"`
+		From     string `yaml:"from"`
+	} `yaml:"smtp"`
 	Server struct {
 		Port      int    `yaml:"port"`
 		UploadDir string `yaml:"upload_dir"`
+		// public address of the calendar, used for links to it
+		Url string `yaml:"url"`
+		// header, in which a reverse-proxy passes the address of the client, e.g. "X-Real-IP"
+		ProxyHeader string `yaml:"proxy_header"`
+		// addresses or ranges of the reverse-proxies, whose header is trusted
+		TrustedProxies []string `yaml:"trusted_proxies"`
 	} `yaml:"server"`
 }
 
@@ -38,6 +141,31 @@ var Config ConfigYaml
 func loadConfig() ConfigYaml {
 	config := ConfigYaml{}
 
+	// defaults for values, which might be missing in the config-file
+	config.ClientSession.Keyset = "keyset.json"
+	config.ClientSession.Algorithm = "HS256"
+	config.ClientSession.AccessExpire = "15m"
+	config.ClientSession.MaxLifetime = "720h"
+	config.Setup.Owner = "admin"
+	config.Comments.MaxDepth = 3
+	config.Comments.OpenDays = 1
+	config.Comments.MaxPerUser = 1
+	config.Comments.MinLength = 1
+	config.Comments.Visibility = "public"
+	config.Comments.EditWindow = "15m"
+	config.Trash.Retention = "720h"
+	config.Invites.Expire = "168h"
+	config.Login.MaxFailures = 5
+	config.Login.Lockout = "15m"
+	config.Login.Backoff = "1s"
+	config.Login.MaxBackoff = "5m"
+	config.Passwords.MinLength = 8
+	config.TwoFactor.Issuer = "advent-server"
+	config.Oidc.Scopes = []string{"openid", "profile", "email"}
+	config.Oidc.NameClaim = "preferred_username"
+	config.MagicLink.Expire = "15m"
+	config.Smtp.Port = 587
+
 	yamlFile, err := os.ReadFile(CONFIG_PATH)
 	if err != nil {
 		panic(fmt.Sprintf("Error opening config-file: %v", err))
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// a change of the database-schema, so databases created by an older setup can be brought up to date
+type migration struct {
+	description string
+	// reports wether the migration was already applied, so "migrate" can be run repeatedly
+	applied    func(db *sql.DB) (bool, error)
+	statements []string
+}
+
+func hasTable(table string) func(db *sql.DB) (bool, error) {
+	return func(db *sql.DB) (bool, error) {
+		var count int
+
+		err := db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?", table).Scan(&count)
+
+		return count > 0, err
+	}
+}
+
+func hasColumn(table, column string) func(db *sql.DB) (bool, error) {
+	return func(db *sql.DB) (bool, error) {
+		var count int
+
+		err := db.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?", table, column).Scan(&count)
+
+		return count > 0, err
+	}
+}
+
+// creates a missing table with its definition from "setup.sql"
+func createTable(tables map[string]string, table string) migration {
+	return migration{
+		description: fmt.Sprintf("create table %q", table),
+		applied:     hasTable(table),
+		statements:  []string{tables[table]},
+	}
+}
+
+// adds a missing column with the given definition
+func addColumn(table, column, definition string) migration {
+	return migration{
+		description: fmt.Sprintf("add column %q to table %q", column, table),
+		applied:     hasColumn(table, column),
+		statements:  []string{fmt.Sprintf("ALTER TABLE %s ADD %s %s", table, column, definition)},
+	}
+}
+
+// reads the create-commands of the tables from the sql-script
+func tableDefinitions(sqlScriptCommands []byte) map[string]string {
+	tables := map[string]string{}
+
+	for _, match := range regexp.MustCompile(`(?im)^create table (\w+) .*$`).FindAllSubmatch(sqlScriptCommands, -1) {
+		tables[string(match[1])] = string(match[0])
+	}
+
+	return tables
+}
+
+// the schema-changes in the order they were introduced
+func migrations(tables map[string]string) []migration {
+	return []migration{
+		createTable(tables, "layouts"),
+		createTable(tables, "polls"),
+		createTable(tables, "options"),
+		createTable(tables, "votes"),
+		createTable(tables, "reactions"),
+		addColumn("comments", "parent", "int NOT NULL DEFAULT 0 AFTER uid"),
+		createTable(tables, "rules"),
+		addColumn("comments", "created", "datetime NOT NULL DEFAULT CURRENT_TIMESTAMP AFTER answer"),
+		addColumn("comments", "edited", "datetime AFTER created"),
+		createTable(tables, "edits"),
+		addColumn("comments", "state", "varchar(16) NOT NULL DEFAULT 'approved' AFTER edited"),
+		addColumn("comments", "reason", "text AFTER state"),
+		addColumn("users", "deleted", "datetime"),
+		addColumn("comments", "deleted", "datetime"),
+		addColumn("users", "display", "text AFTER tid"),
+		addColumn("users", "avatar", "text AFTER display"),
+		addColumn("rules", "visibility", "varchar(16)"),
+		addColumn("users", "role", "varchar(16) NOT NULL DEFAULT 'member' AFTER uid"),
+		{
+			// the previous "admin"-user becomes the owner, so there is always someone to manage the roles
+			description: "convert the admin-flag of the users into roles",
+			applied: func(db *sql.DB) (bool, error) {
+				admin, err := hasColumn("users", "admin")(db)
+
+				return !admin, err
+			},
+			statements: []string{
+				"UPDATE users SET role = IF(admin, 'editor', 'member')",
+				"UPDATE users SET role = 'owner' WHERE admin ORDER BY name = 'admin' DESC, uid LIMIT 1",
+				"ALTER TABLE users DROP admin",
+			},
+		},
+		addColumn("users", "totp_secret", "text"),
+		addColumn("users", "totp_enabled", "bool NOT NULL DEFAULT 0"),
+		addColumn("users", "totp_counter", "bigint NOT NULL DEFAULT 0"),
+		createTable(tables, "recovery"),
+		createTable(tables, "invites"),
+		createTable(tables, "sessions"),
+		// sessions from before can't be refreshed, since their refresh-token is empty
+		addColumn("sessions", "refresh", "binary(32) NOT NULL AFTER seen"),
+		addColumn("sessions", "previous", "binary(32) AFTER refresh"),
+		addColumn("sessions", "refreshed", "datetime NOT NULL DEFAULT CURRENT_TIMESTAMP AFTER previous"),
+		addColumn("users", "email", "varchar(255) UNIQUE"),
+		addColumn("users", "oidc_subject", "varchar(255) UNIQUE"),
+		createTable(tables, "magic_links"),
+	}
+}
+
+// applies all missing migrations to the database
+func migrate(db *sql.DB, sqlScriptCommands []byte) error {
+	for _, m := range migrations(tableDefinitions(sqlScriptCommands)) {
+		if applied, err := m.applied(db); err != nil {
+			return err
+		} else if applied {
+			continue
+		}
+
+		fmt.Printf("\t%s\n", m.description)
+
+		for _, statement := range m.statements {
+			if statement == "" {
+				return fmt.Errorf("can't %s: no definition in \"setup.sql\"", m.description)
+			} else if _, err := db.Exec(statement); err != nil {
+				return fmt.Errorf("can't %s: %v", m.description, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -56,6 +56,19 @@ func main() {
 		sqlScriptCommands = c
 	}
 
+	// databases of an older setup are only updated to the current schema
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		fmt.Println("migrating the database")
+
+		if err := migrate(db, sqlScriptCommands); err != nil {
+			exit(err)
+		}
+
+		fmt.Println("database is up to date")
+
+		return
+	}
+
 	// read the currently availabe tables
 	fmt.Println("reading available tables in database")
 	if rows, err := db.Query("SHOW TABLES"); err != nil {
@@ -76,7 +89,7 @@ func main() {
 					exit(err)
 				} else {
 					if match {
-						exit(fmt.Errorf("can't setup databases: table %q already exists", name))
+						exit(fmt.Errorf("can't setup databases: table %q already exists, use \"make migrate\" to update it", name))
 					}
 				}
 			}
@@ -104,27 +117,27 @@ func main() {
 		}
 	}
 
-	fmt.Println("Creating admin-password:")
+	fmt.Println("Creating owner-password:")
 
-	// create an admin-password
+	// create an owner-password
 	const passwordLength = 20
 	password := createPassword(passwordLength)
 
-	// hash the admin-password
+	// hash the owner-password
 	if passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost); err != nil {
 		exit(err)
 	} else {
 		fmt.Println("\thashed password")
 
-		// create an admin-user
-		if _, err := db.Exec("INSERT INTO users (name, admin, password) VALUES ('admin', 1, ?)", passwordHash); err != nil {
+		// create the owner
+		if _, err := db.Exec("INSERT INTO users (name, role, password) VALUES (?, 'owner', ?)", Config.Setup.Owner, passwordHash); err != nil {
 			exit(err)
 		}
 
 		fmt.Println("\twrote hashed password to database")
 	}
 
-	fmt.Printf("created user \"admin\" with password %s\n", password)
+	fmt.Printf("created owner %q with password %s\n", Config.Setup.Owner, password)
 
 	// create a jwt-signature
 	Config.ClientSession.JwtSignature = createPassword(100)
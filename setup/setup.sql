@@ -1,3 +1,14 @@
-CREATE TABLE users (uid int NOT NULL KEY auto_increment, admin bool NOT NULL DEFAULT 0, name text NOT NULL, password binary(62) NOT NULL, tid int NOT NULL DEFAULT 0);
+CREATE TABLE users (uid int NOT NULL KEY auto_increment, role varchar(16) NOT NULL DEFAULT 'member', name text NOT NULL, password binary(62) NOT NULL, tid int NOT NULL DEFAULT 0, display text, avatar text, deleted datetime, email varchar(255) UNIQUE, oidc_subject varchar(255) UNIQUE, totp_secret text, totp_enabled bool NOT NULL DEFAULT 0, totp_counter bigint NOT NULL DEFAULT 0);
 CREATE TABLE posts (pid int NOT NULL KEY auto_increment, content text NOT NULL DEFAULT '', date char(14) NOT NULL UNIQUE);
-CREATE TABLE comments (cid int NOT NULL KEY auto_increment, pid int NOT NULL, uid int NOT NULL, text text NOT NULL, answer text);
\ No newline at end of file
+CREATE TABLE comments (cid int NOT NULL KEY auto_increment, pid int NOT NULL, uid int NOT NULL, parent int NOT NULL DEFAULT 0, text text NOT NULL, answer text, created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, edited datetime, state varchar(16) NOT NULL DEFAULT 'approved', reason text, deleted datetime);
+CREATE TABLE layouts (uid int NOT NULL KEY, layout text NOT NULL);
+CREATE TABLE polls (plid int NOT NULL KEY auto_increment, pid int NOT NULL, question text NOT NULL, multiple bool NOT NULL DEFAULT 0);
+CREATE TABLE options (oid int NOT NULL KEY auto_increment, plid int NOT NULL, text text NOT NULL);
+CREATE TABLE votes (plid int NOT NULL, oid int NOT NULL, uid int NOT NULL, UNIQUE (oid, uid), INDEX (plid, uid));
+CREATE TABLE reactions (rid int NOT NULL KEY auto_increment, pid int NOT NULL DEFAULT 0, cid int NOT NULL DEFAULT 0, uid int NOT NULL, emoji varchar(32) NOT NULL, UNIQUE (pid, cid, uid, emoji));
+CREATE TABLE rules (pid int NOT NULL KEY, open_days int, allow_past bool, max_per_user int, min_length int, max_length int, visibility varchar(16));
+CREATE TABLE edits (cid int NOT NULL, text text NOT NULL, edited datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, INDEX (cid));
+CREATE TABLE recovery (uid int NOT NULL, code binary(60) NOT NULL, INDEX (uid));
+CREATE TABLE invites (iid int NOT NULL KEY auto_increment, token binary(32) NOT NULL UNIQUE, role varchar(16) NOT NULL DEFAULT 'member', creator int NOT NULL, created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, expires datetime NOT NULL, used datetime, uid int);
+CREATE TABLE sessions (sid int NOT NULL KEY auto_increment, uid int NOT NULL, device text NOT NULL, ip varchar(45) NOT NULL, agent text NOT NULL, created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, seen datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, refresh binary(32) NOT NULL, previous binary(32), refreshed datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, revoked datetime, INDEX (uid));
+CREATE TABLE magic_links (mid int NOT NULL KEY auto_increment, uid int NOT NULL, created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, expires datetime NOT NULL, used datetime, INDEX (uid));
\ No newline at end of file